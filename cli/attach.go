@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/beltranaceves/gontainers/container"
+	"github.com/beltranaceves/gontainers/container/state"
+)
+
+func attach() error {
+	flags := flag.NewFlagSet("attach", flag.ContinueOnError)
+	detachKeys := flags.String("detach-keys", container.DefaultDetachKeys, "Key sequence for detaching from the container")
+	noStdin := flags.Bool("no-stdin", false, "Do not attach STDIN")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("exactly one container ID required for attach")
+	}
+
+	keys, err := container.ParseDetachKeys(*detachKeys)
+	if err != nil {
+		return err
+	}
+
+	c, record, err := container.Load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if record.Status != state.Running && record.Status != state.Paused {
+		return fmt.Errorf("container %s is not running", flags.Arg(0))
+	}
+
+	return c.Attach(container.AttachOptions{DetachKeys: keys, NoStdin: *noStdin})
+}
@@ -3,16 +3,26 @@ package cli
 import (
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container"
 )
 
 type CLI struct {
 }
 
+// containerInfo is a row of `ps`, exported so --format templates and json can use it
 type containerInfo struct {
-	id      string
-	command string
-	pid     string
-	status  string
+	ID        string
+	Image     string
+	Command   string
+	Created   string
+	CreatedAt time.Time
+	Status    string
+	State     string
+	Process   string `json:",omitempty"`
+	Pid       string
+	Names     string
 }
 
 func NewCLI() *CLI {
@@ -24,8 +34,6 @@ func (c *CLI) Execute() error {
 		return fmt.Errorf("command required")
 	}
 
-	fmt.Printf("Running %v as user %d in process %d \n", os.Args[1:], os.Getuid(), os.Getpid())
-
 	switch os.Args[1] {
 	case "run":
 		return runParent()
@@ -33,8 +41,39 @@ func (c *CLI) Execute() error {
 		return ps()
 	case "stop":
 		return stop()
+	case "kill":
+		return kill()
+	case "inspect":
+		return inspect()
+	case "rm":
+		return rm()
+	case "logs":
+		return logs()
+	case "exec":
+		return execParent()
+	case "attach":
+		return attach()
+	case "pull":
+		return pull()
+	case "images":
+		return images()
+	case "rmi":
+		return rmi()
+	case "login":
+		return login()
+	case "logout":
+		return logout()
 	case "child":
 		return runChild()
+	case "exec-child":
+		return container.ExecInit()
+	case "overlay-check":
+		return container.OverlayCheck()
+	case "shim":
+		if len(os.Args) < 3 {
+			return fmt.Errorf("container ID required for shim")
+		}
+		return container.Supervise(os.Args[2])
 	default:
 		return fmt.Errorf("unknown command: %s", os.Args[1])
 	}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/beltranaceves/gontainers/container"
+	"github.com/beltranaceves/gontainers/container/state"
+)
+
+// execParent runs `exec [OPTIONS] ID COMMAND [ARG...]`
+func execParent() error {
+	var (
+		workdir, user string
+		env           listFlag
+	)
+
+	flags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gontainers exec [OPTIONS] CONTAINER COMMAND [ARG...]")
+		flags.PrintDefaults()
+	}
+	flags.Var(&env, "e", "Shorthand for --env")
+	flags.Var(&env, "env", "Set environment variables (KEY=VALUE, or KEY to copy it from the host)")
+	flags.StringVar(&workdir, "w", "", "Shorthand for --workdir")
+	flags.StringVar(&workdir, "workdir", "", "Working directory inside the container")
+	flags.StringVar(&user, "u", "", "Shorthand for --user")
+	flags.StringVar(&user, "user", "", "Username or UID (format: <name|uid>[:<group|gid>])")
+	interactive := flags.Bool("i", false, "Shorthand for --interactive")
+	flags.BoolVar(interactive, "interactive", false, "Keep STDIN open")
+	tty := flags.Bool("t", false, "Shorthand for --tty")
+	flags.BoolVar(tty, "tty", false, "Allocate a pseudo-TTY")
+	if err := flags.Parse(expandShortFlags(flags, os.Args[2:])); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return fmt.Errorf("container ID and command required for exec")
+	}
+
+	c, record, err := container.Load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if record.Status != state.Running {
+		return fmt.Errorf("container %s is not running", flags.Arg(0))
+	}
+
+	process := &container.Process{
+		Command:     flags.Arg(1),
+		Args:        flags.Args()[2:],
+		WorkingDir:  workdir,
+		User:        user,
+		Interactive: *interactive,
+		Tty:         *tty,
+	}
+	for _, variable := range env {
+		if !strings.Contains(variable, "=") {
+			value, ok := os.LookupEnv(variable)
+			if !ok {
+				continue
+			}
+			variable += "=" + value
+		}
+		process.Env = append(process.Env, variable)
+	}
+
+	return c.Exec(process)
+}
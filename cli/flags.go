@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beltranaceves/gontainers/container"
+)
+
+// parseSize parses a human readable byte size such as "512m" or "10mb"
+func parseSize(value string) (int64, error) {
+	multipliers := map[byte]int64{
+		'k': 1 << 10,
+		'm': 1 << 20,
+		'g': 1 << 30,
+		't': 1 << 40,
+	}
+
+	number := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), "b")
+	multiplier := int64(1)
+	if n := len(number); n > 0 {
+		if m, ok := multipliers[number[n-1]]; ok {
+			multiplier = m
+			number = number[:n-1]
+		}
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size: %q", value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
+
+// deviceRateFlag implements flag.Value for the repeatable
+// --device-{read,write}-{bps,iops} DEVICE:RATE flags
+type deviceRateFlag struct {
+	resources *container.ResourceConfig
+	bytes     bool
+	set       func(limit *container.IOLimit, rate uint64)
+}
+
+func (f *deviceRateFlag) String() string {
+	return ""
+}
+
+func (f *deviceRateFlag) Set(value string) error {
+	i := strings.LastIndex(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("expected DEVICE:RATE, got %q", value)
+	}
+	device, rateValue := value[:i], value[i+1:]
+
+	var rate int64
+	var err error
+	if f.bytes {
+		rate, err = parseSize(rateValue)
+	} else {
+		rate, err = strconv.ParseInt(rateValue, 10, 64)
+	}
+	if err != nil || rate <= 0 {
+		return fmt.Errorf("invalid rate %q for device %s", rateValue, device)
+	}
+
+	limit, err := f.resources.DeviceIOLimit(device)
+	if err != nil {
+		return err
+	}
+	f.set(limit, uint64(rate))
+	return nil
+}
+
+// listFlag implements flag.Value for flags that can be repeated
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// expandShortFlags splits grouped single letter boolean flags such as
+// "-it" into "-i" "-t", which the flag package does not understand
+func expandShortFlags(flags *flag.FlagSet, args []string) []string {
+	var expanded []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			// Everything from the first non-flag on belongs to the command
+			return append(expanded, args[i:]...)
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "--") && len(name) > 1 && !strings.Contains(name, "=") && flags.Lookup(name) == nil {
+			var letters []string
+			for _, letter := range name {
+				if !isBoolFlag(flags, string(letter)) {
+					letters = nil
+					break
+				}
+				letters = append(letters, "-"+string(letter))
+			}
+			if letters != nil {
+				expanded = append(expanded, letters...)
+				continue
+			}
+		}
+
+		expanded = append(expanded, arg)
+
+		// Flags taking a value consume the next argument unless given as -flag=value
+		if !strings.Contains(name, "=") && flags.Lookup(name) != nil && !isBoolFlag(flags, name) && i+1 < len(args) {
+			i++
+			expanded = append(expanded, args[i])
+		}
+	}
+	return expanded
+}
+
+func isBoolFlag(flags *flag.FlagSet, name string) bool {
+	f := flags.Lookup(name)
+	if f == nil {
+		return false
+	}
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container"
+	"github.com/beltranaceves/gontainers/image"
+	"github.com/beltranaceves/gontainers/registry"
+	"github.com/beltranaceves/gontainers/term"
+)
+
+// imageInfo is a row of `images`
+type imageInfo struct {
+	Repository string
+	Tag        string
+	Digest     string
+	Created    time.Time
+	Size       int64
+}
+
+func pull() error {
+	flags := flag.NewFlagSet("pull", flag.ContinueOnError)
+	platformFlag := flags.String("platform", "", "Pull the image for os/arch[/variant] instead of this machine's")
+	concurrency := flags.Int("max-concurrent-downloads", image.DefaultConcurrency, "Number of layers downloaded at once")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("exactly one image reference required for pull")
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("--max-concurrent-downloads must be at least 1")
+	}
+
+	options := image.PullOptions{
+		Platform:    registry.DefaultPlatform(),
+		Concurrency: *concurrency,
+		Progress:    jsonProgress(os.Stdout),
+	}
+	if *platformFlag != "" {
+		var err error
+		if options.Platform, err = registry.ParsePlatform(*platformFlag); err != nil {
+			return err
+		}
+	}
+	// Progress bars on a terminal, events a program can read otherwise
+	if term.IsTerminal(os.Stdout) {
+		options.Progress = newProgressBars(os.Stdout).Update
+	}
+
+	_, err := image.Default().Pull(registry.NewClient(), flags.Arg(0), options)
+	return err
+}
+
+func images() error {
+	flags := flag.NewFlagSet("images", flag.ContinueOnError)
+	format := flags.String("format", "table", "Format output using table or json")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+
+	store := image.Default()
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+
+	var infos []imageInfo
+	for _, img := range list {
+		created, err := store.Created(img)
+		if err != nil {
+			return err
+		}
+		repository, tag := splitImageName(img.Name)
+		infos = append(infos, imageInfo{
+			Repository: repository,
+			Tag:        tag,
+			Digest:     img.Manifest.Digest,
+			Created:    created,
+			Size:       img.Size(),
+		})
+	}
+
+	switch *format {
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 12, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "REPOSITORY\tTAG\tDIGEST\tCREATED\tSIZE")
+		for _, info := range infos {
+			digest := info.Digest
+			if len(digest) > len("sha256:")+12 {
+				digest = digest[:len("sha256:")+12]
+			}
+			created := "N/A"
+			if !info.Created.IsZero() {
+				created = humanDuration(time.Since(info.Created)) + " ago"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", info.Repository, info.Tag, digest, created, humanSize(info.Size))
+		}
+		return w.Flush()
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, info := range infos {
+			if err := encoder.Encode(info); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid format %q, expected table or json", *format)
+	}
+}
+
+func rmi() error {
+	flags := flag.NewFlagSet("rmi", flag.ContinueOnError)
+	force := flags.Bool("f", false, "Untag the image even if containers use it")
+	flags.BoolVar(force, "force", false, "Untag the image even if containers use it")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("image reference required for rmi")
+	}
+
+	store := image.Default()
+	for _, ref := range flags.Args() {
+		users, err := container.ImageUsers(ref)
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 && !*force {
+			return fmt.Errorf("cannot remove image %s, it is used by container %s, remove it first or force the removal", ref, strings.Join(users, ", "))
+		}
+
+		// Forcing only removes the reference, the blobs and layers of
+		// containers stay until the containers are gone
+		inUse, err := container.UsedImages()
+		if err != nil {
+			return err
+		}
+		deleted, err := store.Remove(ref, inUse)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", ref)
+		for _, digest := range deleted {
+			fmt.Printf("Deleted: %s\n", digest)
+		}
+	}
+	return nil
+}
+
+// splitImageName splits a stored image name into repository and tag, and
+// drops the implicit Docker Hub prefixes the way references are usually
+// written. Images pulled by digest alone have no tag
+func splitImageName(name string) (string, string) {
+	name, _, _ = strings.Cut(name, "@")
+	repository, tag := name, "<none>"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		repository, tag = name[:i], name[i+1:]
+	}
+	repository = strings.TrimPrefix(repository, "docker.io/")
+	repository = strings.TrimPrefix(repository, "library/")
+	return repository, tag
+}
+
+// humanSize formats a byte count with decimal units, like "7.8MB"
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[0])
+	}
+	return fmt.Sprintf("%.3g%s", value, units[unit])
+}
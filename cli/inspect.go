@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/beltranaceves/gontainers/container"
+	"github.com/beltranaceves/gontainers/container/state"
+)
+
+func inspect() error {
+	if len(os.Args) < 3 {
+		return fmt.Errorf("container ID required for inspect")
+	}
+
+	records := []*state.State{}
+	for _, ref := range os.Args[2:] {
+		_, record, err := container.Load(ref)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/beltranaceves/gontainers/container"
+)
+
+func kill() error {
+	flags := flag.NewFlagSet("kill", flag.ContinueOnError)
+	signalName := flags.String("s", "KILL", "Signal to send to the container, by name or number")
+	flags.StringVar(signalName, "signal", "KILL", "Signal to send to the container, by name or number")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("container ID required for kill")
+	}
+
+	signal, err := container.ParseSignal(*signalName)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+	for _, ref := range flags.Args() {
+		c, _, err := container.Load(ref)
+		if err == nil {
+			err = c.Kill(signal)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = fmt.Errorf("failed to kill some containers")
+			continue
+		}
+		fmt.Println(ref)
+	}
+	return failed
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/beltranaceves/gontainers/registry"
+	"github.com/beltranaceves/gontainers/term"
+)
+
+func login() error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := flags.String("u", "", "Shorthand for --username")
+	flags.StringVar(username, "username", "", "Username")
+	password := flags.String("p", "", "Shorthand for --password")
+	flags.StringVar(password, "password", "", "Password")
+	passwordStdin := flags.Bool("password-stdin", false, "Take the password from stdin")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("at most one registry allowed for login")
+	}
+	host := registryHost(flags.Arg(0))
+
+	input := bufio.NewReader(os.Stdin)
+	if *passwordStdin {
+		if *password != "" {
+			return fmt.Errorf("--password and --password-stdin are mutually exclusive")
+		}
+		if *username == "" {
+			return fmt.Errorf("must provide --username with --password-stdin")
+		}
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return err
+		}
+		*password = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if *username == "" {
+		fmt.Print("Username: ")
+		line, err := input.ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read username: %v", err)
+		}
+		*username = strings.TrimSpace(line)
+	}
+	if *password == "" {
+		fmt.Print("Password: ")
+		line, err := readPassword(input)
+		fmt.Println()
+		if err != nil {
+			return fmt.Errorf("failed to read password: %v", err)
+		}
+		*password = line
+	}
+	if *username == "" || *password == "" {
+		return fmt.Errorf("username and password required")
+	}
+
+	if err := registry.NewClient().Login(host, *username, *password); err != nil {
+		return err
+	}
+	if err := registry.StoreCredentials(host, *username, *password); err != nil {
+		return fmt.Errorf("failed to store credentials: %v", err)
+	}
+	fmt.Println("Login Succeeded")
+	return nil
+}
+
+func logout() error {
+	flags := flag.NewFlagSet("logout", flag.ContinueOnError)
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("at most one registry allowed for logout")
+	}
+	host := registryHost(flags.Arg(0))
+
+	fmt.Printf("Removing login credentials for %s\n", registry.ServerAddress(host))
+	return registry.EraseCredentials(host)
+}
+
+// registryHost turns a login argument such as "https://registry.example.com/"
+// into a host, Docker Hub when it is empty
+func registryHost(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	server = strings.Split(server, "/")[0]
+	if registry.ServerAddress(server) == registry.ServerAddress("") {
+		return "registry-1.docker.io"
+	}
+	return server
+}
+
+// readPassword reads a line with echo turned off when stdin is a terminal
+func readPassword(input *bufio.Reader) (string, error) {
+	if restore, err := term.DisableEcho(os.Stdin); err == nil {
+		defer term.SetTermios(os.Stdin, restore)
+	}
+
+	line, err := input.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
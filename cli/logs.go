@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container"
+)
+
+func logs() error {
+	var since, until, tail string
+
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := flags.Bool("f", false, "Shorthand for --follow")
+	flags.BoolVar(follow, "follow", false, "Follow log output")
+	flags.StringVar(&since, "since", "", "Show logs since a timestamp (2024-01-02T15:04:05Z) or relative (42m)")
+	flags.StringVar(&until, "until", "", "Show logs before a timestamp (2024-01-02T15:04:05Z) or relative (42m)")
+	flags.StringVar(&tail, "n", "all", "Shorthand for --tail")
+	flags.StringVar(&tail, "tail", "all", "Number of lines to show from the end of the logs")
+	timestamps := flags.Bool("t", false, "Shorthand for --timestamps")
+	flags.BoolVar(timestamps, "timestamps", false, "Show timestamps")
+	if err := flags.Parse(expandShortFlags(flags, os.Args[2:])); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("exactly one container ID required for logs")
+	}
+
+	options := container.LogOptions{Tail: -1, Follow: *follow}
+	var err error
+	if options.Since, err = parseTimestamp(since); err != nil {
+		return fmt.Errorf("invalid --since: %v", err)
+	}
+	if options.Until, err = parseTimestamp(until); err != nil {
+		return fmt.Errorf("invalid --until: %v", err)
+	}
+	if tail != "all" {
+		options.Tail, err = strconv.Atoi(tail)
+		if err != nil || options.Tail < 0 {
+			return fmt.Errorf("invalid --tail: %q", tail)
+		}
+	}
+
+	c, _, err := container.Load(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return container.ReadLogs(c.ID, options, func(entry container.LogEntry) error {
+		out := os.Stdout
+		if entry.Stream == "stderr" {
+			out = os.Stderr
+		}
+		if *timestamps {
+			if _, err := fmt.Fprintf(out, "%s ", entry.Time.Format(time.RFC3339Nano)); err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprint(out, entry.Log)
+		return err
+	})
+}
+
+// parseTimestamp accepts RFC 3339 times, durations relative to now and
+// unix seconds. An empty value means no bound
+func parseTimestamp(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a timestamp or duration", value)
+}
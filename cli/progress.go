@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/beltranaceves/gontainers/image"
+)
+
+// progressBarWidth is the number of characters inside a progress bar
+const progressBarWidth = 40
+
+// jsonProgress writes every pull event to w as a line of JSON
+func jsonProgress(w io.Writer) func(image.ProgressEvent) {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(event image.ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		encoder.Encode(event)
+	}
+}
+
+// progressBars shows a line per layer on a terminal that is redrawn in
+// place as the layer progresses
+type progressBars struct {
+	mu    sync.Mutex
+	w     io.Writer
+	ids   []string
+	lines map[string]string
+	drawn int // layer lines below the cursor's line of origin
+}
+
+func newProgressBars(w io.Writer) *progressBars {
+	return &progressBars{w: w, lines: map[string]string{}}
+}
+
+func (p *progressBars) Update(event image.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Messages about the whole image go below the layers, which stay as they are
+	if event.ID == "" {
+		fmt.Fprintln(p.w, event.Status)
+		p.ids = nil
+		p.lines = map[string]string{}
+		p.drawn = 0
+		return
+	}
+
+	if _, ok := p.lines[event.ID]; !ok {
+		p.ids = append(p.ids, event.ID)
+	}
+	p.lines[event.ID] = formatProgress(event)
+
+	var buf bytes.Buffer
+	if p.drawn > 0 {
+		fmt.Fprintf(&buf, "\x1b[%dA", p.drawn)
+	}
+	for _, id := range p.ids {
+		fmt.Fprintf(&buf, "\x1b[2K%s\n", p.lines[id])
+	}
+	p.drawn = len(p.ids)
+	p.w.Write(buf.Bytes())
+}
+
+// formatProgress renders an event like "a9b7e55dd608: Downloading [===>  ] 1.2MB/3.4MB"
+func formatProgress(event image.ProgressEvent) string {
+	line := event.ID + ": " + event.Status
+	if event.Total <= 0 || event.Status != "Downloading" {
+		return line
+	}
+
+	filled := int(event.Current * progressBarWidth / event.Total)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("%s [%s] %s/%s", line, bar, humanSize(event.Current), humanSize(event.Total))
+}
@@ -1,115 +1,285 @@
 package cli
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container"
+	"github.com/beltranaceves/gontainers/container/state"
 )
 
 func ps() error {
+	var filters listFlag
+	var format string
+
+	flags := flag.NewFlagSet("ps", flag.ContinueOnError)
+	all := flags.Bool("a", false, "Show all containers (default shows just running)")
+	flags.BoolVar(all, "all", false, "Show all containers (default shows just running)")
+	quiet := flags.Bool("q", false, "Only display container IDs")
+	flags.BoolVar(quiet, "quiet", false, "Only display container IDs")
+	flags.Var(&filters, "f", "Shorthand for --filter")
+	flags.Var(&filters, "filter", "Filter output based on conditions (status=, name=, image=, id=)")
+	flags.StringVar(&format, "format", "table", "Format output using table, json or a Go template")
+	if err := flags.Parse(expandShortFlags(flags, os.Args[2:])); err != nil {
+		return err
+	}
+
+	matches, err := parseFilters(filters)
+	if err != nil {
+		return err
+	}
+
 	onlineContainers := getOnlineGontainersInfo()
-	offlineContainers := getOfflineGontainersInfo()
-	containers := append(onlineContainers, offlineContainers...)
+	offlineContainers := []containerInfo{}
+	if *all || len(matches["status"]) > 0 {
+		offlineContainers = getOfflineGontainersInfo()
+	}
+
+	var containers []containerInfo
+	for _, info := range append(onlineContainers, offlineContainers...) {
+		if info.matches(matches) {
+			containers = append(containers, info)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].CreatedAt.After(containers[j].CreatedAt)
+	})
+
+	if *quiet {
+		for _, info := range containers {
+			fmt.Println(info.ID)
+		}
+		return nil
+	}
+
+	switch format {
+	case "table":
+		return printTable(containers)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, info := range containers {
+			if err := encoder.Encode(info); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return fmt.Errorf("invalid format: %v", err)
+		}
+		for _, info := range containers {
+			if err := tmpl.Execute(os.Stdout, info); err != nil {
+				return err
+			}
+			fmt.Println()
+		}
+		return nil
+	}
+}
 
+func printTable(containers []containerInfo) error {
 	w := tabwriter.NewWriter(os.Stdout, 12, 8, 2, ' ', 0)
 
-	fmt.Fprintln(w, "CONTAINER ID\tCOMMAND\tPID\tSTATUS")
+	fmt.Fprintln(w, "CONTAINER ID\tIMAGE\tCOMMAND\tCREATED\tSTATUS\tPID\tNAMES")
 	for _, container := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
-			container.id,
-			container.command,
-			container.pid,
-			container.status)
+		command := container.Command
+		if len(command) > 20 {
+			command = command[:19] + "…"
+		}
+		image := container.Image
+		if image == "" {
+			image = "<host>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%q\t%s\t%s\t%s\t%s\n",
+			container.ID,
+			image,
+			command,
+			container.Created,
+			container.Status,
+			container.Pid,
+			container.Names)
 	}
-	w.Flush()
-
-	return nil
+	return w.Flush()
 }
 
-func getOfflineGontainersInfo() []containerInfo {
-	// Implementation to get offline containers
-	// TODO: implement getOfflineGontainersInfo
-	return []containerInfo{}
+// parseFilters turns repeated or comma separated key=value filters into a
+// map. Values for the same key are alternatives, different keys must all match
+func parseFilters(filters []string) (map[string][]string, error) {
+	matches := map[string][]string{}
+	for _, filter := range filters {
+		for _, condition := range strings.Split(filter, ",") {
+			key, value, ok := strings.Cut(condition, "=")
+			if !ok {
+				return nil, fmt.Errorf("bad format of filter %q, expected name=value", condition)
+			}
+			switch key {
+			case "status", "name", "image", "id":
+			default:
+				return nil, fmt.Errorf("invalid filter %q", key)
+			}
+			matches[key] = append(matches[key], value)
+		}
+	}
+	return matches, nil
 }
 
-func getOnlineGontainersInfo() []containerInfo {
+func (info containerInfo) matches(filters map[string][]string) bool {
+	for key, values := range filters {
+		matched := false
+		for _, value := range values {
+			switch key {
+			case "status":
+				matched = info.State == value || (value == "exited" && info.State == string(state.Stopped))
+			case "name":
+				matched = strings.Contains(info.Names, value)
+			case "image":
+				matched = info.Image == value || strings.HasPrefix(info.Image, value+":")
+			case "id":
+				matched = strings.HasPrefix(info.ID, value) || strings.HasPrefix(strings.TrimPrefix(info.ID, "gontainer-"), value)
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
 
+func getOfflineGontainersInfo() []containerInfo {
 	var containers []containerInfo
 
-	processes, err := os.ReadDir("/proc")
+	records, err := container.List()
 	if err != nil {
 		return containers
 	}
 
-	for _, process := range processes {
-		if !process.IsDir() {
+	for _, record := range records {
+		if record.Status == state.Running || record.Status == state.Paused {
 			continue
 		}
 
-		// Skip non-numeric directories (PIDs are numeric)
-		if _, err := fmt.Sscanf(process.Name(), "%d", new(int)); err != nil {
-			continue
+		status := "Created"
+		if record.Status == state.Stopped {
+			status = fmt.Sprintf("Exited (%d) %s ago", record.ExitCode, humanDuration(time.Since(record.Finished)))
 		}
 
-		cmdlinePath := fmt.Sprintf("/proc/%s/cmdline", process.Name())
-		cmdlineBytes, err := os.ReadFile(cmdlinePath)
-		if err != nil {
-			continue
-		}
+		info := newContainerInfo(record)
+		info.Pid = "-"
+		info.Status = status
+		containers = append(containers, info)
+	}
+	return containers
+}
 
-		// Split by null bytes to get individual arguments
-		args := strings.Split(string(cmdlineBytes), "\x00")
-		if len(args) == 0 || !strings.HasPrefix(args[0], "gontainer-") {
-			continue
-		}
+func getOnlineGontainersInfo() []containerInfo {
 
-		// Extract container ID from the process name
-		containerId := strings.TrimPrefix(args[0], "gontainer-")
+	var containers []containerInfo
+
+	records, err := container.List()
+	if err != nil {
+		return containers
+	}
 
-		// The actual command is the second argument (index 1) and onwards
-		var command string
-		if len(args) > 1 {
-			// Join all remaining arguments to form the command
-			command = strings.Join(args[1:len(args)-1], " ") // -1 to remove the empty string at the end
-		} else {
-			command = "<none>"
+	for _, record := range records {
+		if record.Status != state.Running && record.Status != state.Paused {
+			continue
 		}
 
+		// The store knows which containers run, /proc knows what they are doing
+		pid := fmt.Sprintf("%d", record.Pid)
+
 		// Get process state
-		status, _ := os.ReadFile(fmt.Sprintf("/proc/%s/status", process.Name()))
+		status, _ := os.ReadFile(fmt.Sprintf("/proc/%s/status", pid))
 		statusLines := strings.Split(string(status), "\n")
-		state := "UNKNOWN"
+		procState := "UNKNOWN"
 		for _, line := range statusLines {
 			if strings.HasPrefix(line, "State:") {
 				stateParts := strings.SplitN(strings.TrimPrefix(line, "State:"), " ", 2)
 				if len(stateParts) > 0 {
 					// Just take the first character (R for running, S for sleeping, etc.)
-					state = strings.TrimSpace(stateParts[0])
+					procState = strings.TrimSpace(stateParts[0])
 					// Convert to a more user-friendly format
-					switch state {
+					switch procState {
 					case "R":
-						state = "RUNNING"
+						procState = "RUNNING"
 					case "S":
-						state = "SLEEPING"
+						procState = "SLEEPING"
 					case "D":
-						state = "WAITING"
+						procState = "WAITING"
 					case "Z":
-						state = "ZOMBIE"
+						procState = "ZOMBIE"
 					case "T":
-						state = "STOPPED"
+						procState = "STOPPED"
 					}
 				}
 				break
 			}
 		}
 
-		containers = append(containers, containerInfo{
-			id:      containerId,
-			command: command,
-			pid:     process.Name(),
-			status:  state,
-		})
+		info := newContainerInfo(record)
+		info.Pid = pid
+		info.Process = procState
+		info.Status = "Up " + humanDuration(time.Since(record.Started))
+		if record.Status == state.Paused {
+			info.Status += " (Paused)"
+		}
+		containers = append(containers, info)
 	}
 	return containers
 }
+
+func newContainerInfo(record *state.State) containerInfo {
+	return containerInfo{
+		ID:        record.ID,
+		Image:     record.Image,
+		Command:   strings.Join(append([]string{record.Command}, record.Args...), " "),
+		Created:   humanDuration(time.Since(record.Created)) + " ago",
+		CreatedAt: record.Created,
+		State:     string(record.Status),
+		Names:     record.Name,
+	}
+}
+
+// humanDuration formats a duration the way "5 minutes ago" reads
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return "Less than a second"
+	case d < time.Minute:
+		return pluralize(int(d.Seconds()), "second")
+	case d < 2*time.Minute:
+		return "About a minute"
+	case d < time.Hour:
+		return pluralize(int(d.Minutes()), "minute")
+	case d < 2*time.Hour:
+		return "About an hour"
+	case d < 48*time.Hour:
+		return pluralize(int(d.Hours()), "hour")
+	case d < 14*24*time.Hour:
+		return pluralize(int(d.Hours()/24), "day")
+	case d < 60*24*time.Hour:
+		return pluralize(int(d.Hours()/24/7), "week")
+	case d < 365*24*time.Hour:
+		return pluralize(int(d.Hours()/24/30), "month")
+	default:
+		return pluralize(int(d.Hours()/24/365), "year")
+	}
+}
+
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/beltranaceves/gontainers/container"
+)
+
+func rm() error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	force := flags.Bool("f", false, "Force the removal of a running container")
+	flags.BoolVar(force, "force", false, "Force the removal of a running container")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("container ID required for rm")
+	}
+
+	for _, ref := range flags.Args() {
+		if err := container.Remove(ref, *force); err != nil {
+			return err
+		}
+		fmt.Println(ref)
+	}
+	return nil
+}
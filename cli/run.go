@@ -1,86 +1,359 @@
 package cli
 
 import (
+	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/beltranaceves/gontainers/container"
 )
 
 func runParent() error {
-	if len(os.Args) < 3 {
-		return fmt.Errorf("command required for run")
+	c, err := parseRunFlags(os.Args[2:])
+	if err != nil {
+		return err
 	}
 
-	// Extract command and arguments
-	command := os.Args[2]
-	args := []string{}
-	if len(os.Args) > 3 {
-		args = os.Args[3:]
+	if len(c.Ports) > 0 {
+		fmt.Fprintln(os.Stderr, "WARNING: containers have no network yet, published ports are ignored")
 	}
 
-	// Create a new container
-	container := container.NewContainer(command, args)
-
-	// Set up filesystem
-	// fs := container.SetupFilesystem()
-	// if err := fs.Setup(); err != nil {
-	// 	return fmt.Errorf("failed to set up filesystem: %v", err)
-	// }
-
 	// Set up network if needed
 	// network := container.SetupNetwork()
 	// if err := network.Setup(); err != nil {
 	// 	return fmt.Errorf("failed to set up network: %v", err)
 	// }
 
+	// A detached container is handed to a supervisor and only its ID printed
+	if c.Detach {
+		if err := c.StartDetached(); err != nil {
+			return fmt.Errorf("failed to start container: %v", err)
+		}
+		fmt.Println(c.ID)
+		return nil
+	}
+
 	// Start the container
-	if err := container.Start(); err != nil {
+	if err := c.Start(); err != nil {
+		if _, ok := err.(*container.ExitError); ok {
+			return err
+		}
 		return fmt.Errorf("failed to start container: %v", err)
 	}
 
-	fmt.Printf("Container started with ID: %s\n", container.ID)
+	fmt.Printf("Container started with ID: %s\n", c.ID)
 	return nil
 }
 
-func runChild() error {
-	fmt.Printf("Running %v \n", os.Args[2:])
-	// cg()
-	// TODO: before CHROOT, I should download/cache the filesystem
-	// TODO: CHROOT should be run here, and it should be delegated to the container package
-	cmd := exec.Command(os.Args[2], os.Args[3:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	// must(syscall.Sethostname([]byte("container")))
-	// must(syscall.Chroot("/home/beltran/ubuntufs"))
-	// must(os.Chdir("/"))
-	// must(syscall.Mount("proc", "proc", "proc", 0, ""))
-	// must(syscall.Mount("thing", "mytemp", "tempfs", 0, ""))
-
-	must(cmd.Run())
-
-	// must(syscall.Unmount("proc", 0))
-	// must(syscall.Unmount("thing", 0))
+// limitControllers maps the limit flags to the cgroup controller enforcing them
+var limitControllers = map[string]string{
+	"memory":            "memory",
+	"cpus":              "cpu",
+	"pids-limit":        "pids",
+	"cpuset-cpus":       "cpuset",
+	"cpuset-mems":       "cpuset",
+	"device-read-bps":   "io",
+	"device-write-bps":  "io",
+	"device-read-iops":  "io",
+	"device-write-iops": "io",
+}
+
+// parseRunFlags builds a container from `run [OPTIONS] [--] COMMAND [ARG...]`
+func parseRunFlags(args []string) (*container.Container, error) {
+	resources := container.DefaultResourceConfig()
+	var (
+		name, hostname, workdir, user, image, memory string
+		entrypoint                                   string
+		env, envFiles, volumes, publish, logOpts     listFlag
+		autoRemove, detach, interactive, tty         bool
+		cpus                                         float64
+	)
+
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: gontainers run [OPTIONS] [IMAGE] [--] [COMMAND] [ARG...]")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&name, "name", "", "Assign a name to the container")
+	flags.StringVar(&hostname, "hostname", "", "Container host name")
+	flags.StringVar(&image, "image", "", "Image to run the command in")
+	flags.StringVar(&entrypoint, "entrypoint", "", "Overwrite the default entrypoint of the image")
+	flags.Var(&env, "e", "Shorthand for --env")
+	flags.Var(&env, "env", "Set environment variables (KEY=VALUE, or KEY to copy it from the host)")
+	flags.Var(&envFiles, "env-file", "Read in a file of environment variables")
+	flags.StringVar(&workdir, "w", "", "Shorthand for --workdir")
+	flags.StringVar(&workdir, "workdir", "", "Working directory inside the container")
+	flags.StringVar(&user, "u", "", "Shorthand for --user")
+	flags.StringVar(&user, "user", "", "Username or UID (format: <name|uid>[:<group|gid>])")
+	flags.Var(&volumes, "v", "Shorthand for --volume")
+	flags.Var(&volumes, "volume", "Bind mount a volume (HOST:CONTAINER[:ro])")
+	flags.Var(&publish, "p", "Shorthand for --publish")
+	flags.Var(&publish, "publish", "Publish a container's port to the host ([IP:]HOSTPORT:CONTAINERPORT[/PROTO])")
+	flags.BoolVar(&autoRemove, "rm", false, "Automatically remove the container when it exits")
+	flags.BoolVar(&detach, "d", false, "Shorthand for --detach")
+	flags.BoolVar(&detach, "detach", false, "Run container in background and print container ID")
+	flags.BoolVar(&interactive, "i", false, "Shorthand for --interactive")
+	flags.BoolVar(&interactive, "interactive", false, "Keep STDIN open")
+	flags.BoolVar(&tty, "t", false, "Shorthand for --tty")
+	flags.BoolVar(&tty, "tty", false, "Allocate a pseudo-TTY")
+	flags.Var(&logOpts, "log-opt", "Log driver options (max-size=10m, max-file=3)")
+	flags.StringVar(&memory, "memory", "", "Memory limit (e.g. 512m, 2g)")
+	flags.Float64Var(&cpus, "cpus", 0, "Number of CPUs")
+	flags.Int64Var(&resources.PidsLimit, "pids-limit", 0, "Maximum number of processes, 0 for unlimited")
+	flags.StringVar(&resources.CpusetCpus, "cpuset-cpus", "", "CPUs the container may run on (0-3, 0,1)")
+	flags.StringVar(&resources.CpusetMems, "cpuset-mems", "", "Memory nodes the container may allocate from (0-3, 0,1)")
+	flags.Var(&deviceRateFlag{resources: resources, bytes: true, set: func(l *container.IOLimit, rate uint64) { l.ReadBps = rate }},
+		"device-read-bps", "Limit read rate from a device (DEVICE:RATE, e.g. /dev/sda:10mb)")
+	flags.Var(&deviceRateFlag{resources: resources, bytes: true, set: func(l *container.IOLimit, rate uint64) { l.WriteBps = rate }},
+		"device-write-bps", "Limit write rate to a device (DEVICE:RATE, e.g. /dev/sda:10mb)")
+	flags.Var(&deviceRateFlag{resources: resources, set: func(l *container.IOLimit, rate uint64) { l.ReadIOPS = rate }},
+		"device-read-iops", "Limit read operations per second from a device (DEVICE:RATE)")
+	flags.Var(&deviceRateFlag{resources: resources, set: func(l *container.IOLimit, rate uint64) { l.WriteIOPS = rate }},
+		"device-write-iops", "Limit write operations per second to a device (DEVICE:RATE)")
+	if err := flags.Parse(expandShortFlags(flags, args)); err != nil {
+		return nil, err
+	}
+
+	// Without --image the first argument names the image when it has been pulled
+	args = flags.Args()
+	if image == "" && len(args) > 0 && container.ImageExists(args[0]) {
+		image, args = args[0], args[1:]
+	}
+
+	// An empty --entrypoint still clears the image's entrypoint
+	var entrypointOverride *string
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "entrypoint" {
+			entrypointOverride = &entrypoint
+		}
+	})
+	if image == "" && len(args) < 1 && entrypointOverride == nil {
+		return nil, fmt.Errorf("command required for run")
+	}
 
+	if memory != "" {
+		size, err := parseSize(memory)
+		if err != nil {
+			return nil, err
+		}
+		resources.Memory = size
+	}
+	if cpus < 0 {
+		return nil, fmt.Errorf("invalid --cpus: %v", cpus)
+	}
+	if cpus > 0 {
+		resources.CPUQuota = int64(cpus * float64(resources.CPUPeriod))
+	}
+
+	// Limits given on the command line have to be enforced, unlike the defaults
+	flags.Visit(func(f *flag.Flag) {
+		controller, ok := limitControllers[f.Name]
+		if ok && !slices.Contains(resources.Required, controller) {
+			resources.Required = append(resources.Required, controller)
+		}
+	})
+
+	// Extract command and arguments
+	c := container.NewContainer("", nil)
+	if len(args) > 0 {
+		c.Command, c.Args = args[0], args[1:]
+	}
+	c.Name = name
+	c.Hostname = hostname
+	c.Image = image
+	c.WorkingDir = workdir
+	c.User = user
+	c.Resource = resources
+	c.AutoRemove = autoRemove
+	c.Detach = detach
+	c.Interactive = interactive
+	c.Tty = tty
+
+	// Variables from files come first so that -e can override them
+	for _, path := range envFiles {
+		variables, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c.Env = append(c.Env, variables...)
+	}
+	for _, variable := range env {
+		if !strings.Contains(variable, "=") {
+			value, ok := os.LookupEnv(variable)
+			if !ok {
+				continue
+			}
+			variable += "=" + value
+		}
+		c.Env = append(c.Env, variable)
+	}
+
+	for _, volume := range volumes {
+		mount, err := parseVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		c.Mounts = append(c.Mounts, mount)
+	}
+
+	for _, option := range logOpts {
+		if err := parseLogOpt(&c.Log, option); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, port := range publish {
+		mapping, err := parsePortMapping(port)
+		if err != nil {
+			return nil, err
+		}
+		c.Ports = append(c.Ports, mapping)
+	}
+
+	// The image config fills in whatever was not given on the command line
+	config := &container.ImageConfig{}
+	if image != "" {
+		var err error
+		if config, err = container.LoadImageConfig(image); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ApplyImageConfig(config, entrypointOverride); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// parseLogOpt applies a single KEY=VALUE --log-opt to config
+func parseLogOpt(config *container.LogConfig, option string) error {
+	key, value, ok := strings.Cut(option, "=")
+	if !ok {
+		return fmt.Errorf("invalid log option %q, expected KEY=VALUE", option)
+	}
+	switch key {
+	case "max-size":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		config.MaxSize = size
+	case "max-file":
+		files, err := strconv.Atoi(value)
+		if err != nil || files < 1 {
+			return fmt.Errorf("invalid max-file %q", value)
+		}
+		config.MaxFiles = files
+	default:
+		return fmt.Errorf("unknown log option %q", key)
+	}
 	return nil
 }
 
-func cg() {
-	cgroups := "/sys/fs/cgroup"
-	pids := filepath.Join(cgroups, "pids")
-	os.Mkdir(filepath.Join(pids, "beltran"), 0755)
-	must(os.WriteFile(filepath.Join(pids, "beltran", "pids.max"), []byte("20"), 0700))
-	// Removes the new cgroup in place after the container exits
-	must(os.WriteFile(filepath.Join(pids, "beltran", "notify_on_release"), []byte("1"), 0700))
-	must(os.WriteFile(filepath.Join(pids, "beltran", "cgroup.procs"), []byte(fmt.Sprintf("%d", os.Getpid())), 0700))
+// readEnvFile reads KEY=VALUE lines, skipping blank lines and comments.
+// A bare KEY copies the variable from the host like -e does
+func readEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %v", err)
+	}
+
+	var variables []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "=") {
+			value, ok := os.LookupEnv(line)
+			if !ok {
+				continue
+			}
+			line += "=" + value
+		}
+		variables = append(variables, line)
+	}
+	return variables, nil
 }
 
-func must(err error) {
+// parseVolume parses HOST:CONTAINER[:ro|rw]
+func parseVolume(volume string) (container.Mount, error) {
+	parts := strings.Split(volume, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return container.Mount{}, fmt.Errorf("invalid volume %q, expected HOST:CONTAINER[:ro]", volume)
+	}
+
+	source, err := filepath.Abs(parts[0])
 	if err != nil {
-		panic(err)
+		return container.Mount{}, err
+	}
+	if !filepath.IsAbs(parts[1]) {
+		return container.Mount{}, fmt.Errorf("invalid volume %q, container path must be absolute", volume)
+	}
+
+	mount := container.Mount{Source: source, Destination: filepath.Clean(parts[1])}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			mount.ReadOnly = true
+		case "rw":
+		default:
+			return container.Mount{}, fmt.Errorf("invalid volume mode %q", parts[2])
+		}
+	}
+	return mount, nil
+}
+
+// parsePortMapping parses [IP:]HOSTPORT:CONTAINERPORT[/PROTO] or CONTAINERPORT[/PROTO]
+func parsePortMapping(value string) (container.PortMapping, error) {
+	mapping := container.PortMapping{Protocol: "tcp"}
+
+	spec, protocol, hasProtocol := strings.Cut(value, "/")
+	if hasProtocol {
+		if protocol != "tcp" && protocol != "udp" {
+			return mapping, fmt.Errorf("invalid protocol %q in %q", protocol, value)
+		}
+		mapping.Protocol = protocol
+	}
+
+	parts := strings.Split(spec, ":")
+	var hostPort, containerPort string
+	switch len(parts) {
+	case 1:
+		containerPort = parts[0]
+	case 2:
+		hostPort, containerPort = parts[0], parts[1]
+	case 3:
+		mapping.HostIP, hostPort, containerPort = parts[0], parts[1], parts[2]
+	default:
+		return mapping, fmt.Errorf("invalid port mapping %q", value)
+	}
+
+	var err error
+	if mapping.ContainerPort, err = parsePort(containerPort); err != nil {
+		return mapping, fmt.Errorf("invalid port mapping %q: %v", value, err)
+	}
+	mapping.HostPort = mapping.ContainerPort
+	if hostPort != "" {
+		if mapping.HostPort, err = parsePort(hostPort); err != nil {
+			return mapping, fmt.Errorf("invalid port mapping %q: %v", value, err)
+		}
+	}
+	return mapping, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
 	}
+	return port, nil
+}
+
+func runChild() error {
+	return container.Init()
 }
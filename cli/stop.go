@@ -1,13 +1,47 @@
 package cli
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container"
+	"github.com/beltranaceves/gontainers/container/state"
 )
 
 func stop() error {
-	if len(os.Args) < 3 {
+	flags := flag.NewFlagSet("stop", flag.ContinueOnError)
+	seconds := flags.Int("t", 10, "Seconds to wait for the container to exit before killing it")
+	flags.IntVar(seconds, "time", 10, "Seconds to wait for the container to exit before killing it")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
 		return fmt.Errorf("container ID required for stop")
 	}
-	return nil
+	if *seconds < 0 {
+		return fmt.Errorf("invalid timeout: %d", *seconds)
+	}
+
+	// Keep going on errors so one bad ID doesn't leave the rest running
+	var failed error
+	for _, ref := range flags.Args() {
+		c, record, err := container.Load(ref)
+		if err == nil && record.Status == state.Stopped {
+			// Stopping a stopped container is not an error
+			fmt.Println(ref)
+			continue
+		}
+		if err == nil {
+			err = c.Stop(time.Duration(*seconds) * time.Second)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed = fmt.Errorf("failed to stop some containers")
+			continue
+		}
+		fmt.Println(ref)
+	}
+	return failed
 }
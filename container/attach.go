@@ -0,0 +1,387 @@
+package container
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container/state"
+	"github.com/beltranaceves/gontainers/term"
+)
+
+// The attach socket carries frames of a one byte stream, a big endian
+// uint32 length and the payload, so one connection can multiplex stdio
+const (
+	streamStdin byte = iota
+	streamStdout
+	streamStderr
+	streamResize // payload is the rows and columns as big endian uint16
+)
+
+// DefaultDetachKeys is the key sequence that detaches from a container
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// attachWriteTimeout is how long a client may hold up the container's output
+const attachWriteTimeout = 5 * time.Second
+
+// maxFrameSize bounds the payload of a frame, so a client can't make the
+// supervisor allocate whatever length it sends. Stdio is copied in far
+// smaller chunks
+const maxFrameSize = 1 << 20
+
+// maxSocketPath is the size of sun_path less its terminating NUL
+const maxSocketPath = 107
+
+// AttachSocket returns the socket a detached container's supervisor serves
+func AttachSocket(id string) string {
+	return filepath.Join(state.Default().Dir(id), "attach.sock")
+}
+
+// socketPath returns what to bind or connect to for the socket at path.
+// Paths too long for sun_path are reached through the directory's
+// /proc/self/fd entry, the returned directory has to stay open until the
+// socket is bound or connected
+func socketPath(path string) (string, *os.File, error) {
+	if len(path) <= maxSocketPath {
+		return path, nil, nil
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("/proc/self/fd/%d/%s", dir.Fd(), filepath.Base(path)), dir, nil
+}
+
+func writeFrame(w io.Writer, stream byte, payload []byte) error {
+	frame := make([]byte, 5+len(payload))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:5])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the limit of %d", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// attachServer hands a container's output to every attached client and
+// their input to the container
+type attachServer struct {
+	listener *net.UnixListener
+	path     string
+	stdin    io.Writer // nil unless the container is interactive
+	resize   chan term.Winsize
+
+	mu      sync.Mutex
+	clients map[net.Conn]bool
+}
+
+func listenAttach(id string, stdin io.Writer) (*attachServer, error) {
+	path := AttachSocket(id)
+	os.Remove(path)
+	bind, dir, err := socketPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on attach socket: %v", err)
+	}
+	if dir != nil {
+		defer dir.Close()
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: bind, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on attach socket: %v", err)
+	}
+	// The name bound may be a /proc path that no longer leads there by
+	// the time the listener is closed
+	listener.SetUnlinkOnClose(false)
+
+	server := &attachServer{
+		listener: listener,
+		path:     path,
+		stdin:    stdin,
+		resize:   make(chan term.Winsize, 1),
+		clients:  map[net.Conn]bool{},
+	}
+	go server.serve()
+	return server, nil
+}
+
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = true
+		s.mu.Unlock()
+		go s.receive(conn)
+	}
+}
+
+// receive forwards a client's input until it goes away
+func (s *attachServer) receive(conn net.Conn) {
+	defer s.drop(conn)
+	for {
+		stream, payload, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		switch stream {
+		case streamStdin:
+			if s.stdin != nil {
+				s.stdin.Write(payload)
+			}
+		case streamResize:
+			var size term.Winsize
+			if len(payload) != 4 {
+				continue
+			}
+			size.Row = binary.BigEndian.Uint16(payload[0:2])
+			size.Col = binary.BigEndian.Uint16(payload[2:4])
+			// Only the latest size matters
+			select {
+			case <-s.resize:
+			default:
+			}
+			s.resize <- size
+		}
+	}
+}
+
+func (s *attachServer) drop(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.clients, conn)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// Stream returns a writer that sends everything written to it to all clients
+func (s *attachServer) Stream(stream byte) io.Writer {
+	return &attachStream{server: s, stream: stream}
+}
+
+type attachStream struct {
+	server *attachServer
+	stream byte
+}
+
+func (w *attachStream) Write(p []byte) (int, error) {
+	s := w.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		// A client that stops reading is dropped rather than stalling the container
+		conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if err := writeFrame(conn, w.stream, p); err != nil {
+			delete(s.clients, conn)
+			conn.Close()
+		}
+	}
+	return len(p), nil
+}
+
+// Close stops accepting clients and disconnects the attached ones
+func (s *attachServer) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+	return err
+}
+
+// ParseDetachKeys turns a comma separated list such as "ctrl-p,ctrl-q" into
+// the bytes the terminal sends for it. Single characters stand for themselves
+func ParseDetachKeys(value string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(value, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == 6:
+			c := key[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				keys = append(keys, c-'a'+1)
+			case c == '@' || c == '[' || c == '\\' || c == ']' || c == '^' || c == '_':
+				keys = append(keys, c-'@')
+			default:
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// AttachOptions configures Attach
+type AttachOptions struct {
+	DetachKeys []byte
+	NoStdin    bool
+}
+
+func dialAttach(id string) (net.Conn, error) {
+	path, dir, err := socketPath(AttachSocket(id))
+	if err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		defer dir.Close()
+	}
+	return net.Dial("unix", path)
+}
+
+// Attach connects the caller's stdio to a detached container until the
+// container exits or the detach keys are typed. The container keeps
+// running after detaching
+func (c *Container) Attach(options AttachOptions) error {
+	conn, err := dialAttach(c.ID)
+	if err != nil {
+		return fmt.Errorf("failed to attach to %s, only detached containers can be attached: %v", c.ID, err)
+	}
+	defer conn.Close()
+
+	input := &frameWriter{w: conn}
+	withStdin := c.Interactive && !options.NoStdin
+
+	if terminal := hostTerminal(); c.Tty && terminal != nil {
+		if withStdin {
+			restore, err := term.MakeRaw(terminal)
+			if err != nil {
+				return fmt.Errorf("failed to put terminal into raw mode: %v", err)
+			}
+			defer term.SetTermios(terminal, restore)
+		}
+
+		sendSize := func() {
+			size, err := term.GetWinsize(terminal)
+			if err != nil {
+				return
+			}
+			payload := make([]byte, 4)
+			binary.BigEndian.PutUint16(payload[0:2], size.Row)
+			binary.BigEndian.PutUint16(payload[2:4], size.Col)
+			input.Write(streamResize, payload)
+		}
+
+		// The first size goes out before any input that could depend on it
+		sendSize()
+		resize := make(chan os.Signal, 1)
+		signal.Notify(resize, syscall.SIGWINCH)
+		defer signal.Stop(resize)
+		go func() {
+			for range resize {
+				sendSize()
+			}
+		}()
+	}
+
+	detached := make(chan struct{})
+	if withStdin {
+		go func() {
+			if copyInput(input, os.Stdin, options.DetachKeys) {
+				close(detached)
+				conn.Close()
+			}
+		}()
+	}
+
+	for {
+		stream, payload, err := readFrame(conn)
+		if err != nil {
+			break
+		}
+		switch stream {
+		case streamStdout:
+			os.Stdout.Write(payload)
+		case streamStderr:
+			os.Stderr.Write(payload)
+		}
+	}
+
+	select {
+	case <-detached:
+		return nil
+	default:
+	}
+
+	// The supervisor only hangs up once the container exited and its exit
+	// code is recorded, so it can be passed on
+	record, err := state.Default().Load(c.ID)
+	if err != nil || record.Status != state.Stopped || record.ExitCode == 0 {
+		return nil
+	}
+	return &ExitError{Code: record.ExitCode}
+}
+
+// frameWriter lets several goroutines write frames to one connection
+type frameWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *frameWriter) Write(stream byte, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return writeFrame(f.w, stream, payload)
+}
+
+// copyInput sends stdin to the container until it ends or the detach keys
+// are typed, which it reports. Keys that only start the sequence are held
+// back and sent once it is clear they are not part of it
+func copyInput(input *frameWriter, stdin io.Reader, keys []byte) bool {
+	matched := 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdin.Read(buf)
+		var out []byte
+		for _, b := range buf[:n] {
+			if matched > 0 && b != keys[matched] {
+				out = append(out, keys[:matched]...)
+				matched = 0
+			}
+			if len(keys) > 0 && b == keys[matched] {
+				matched++
+				if matched == len(keys) {
+					input.Write(streamStdin, out)
+					return true
+				}
+				continue
+			}
+			out = append(out, b)
+		}
+		if len(out) > 0 {
+			if input.Write(streamStdin, out) != nil {
+				return false
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
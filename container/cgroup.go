@@ -0,0 +1,298 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupRoot is where the cgroup v2 unified hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupParent groups every container cgroup under a single directory
+const cgroupParent = "gontainers"
+
+// errCgroupsUnavailable is wrapped by errors about cgroups or controllers
+// this process can't use, as opposed to limits the kernel rejects
+var errCgroupsUnavailable = errors.New("cgroup v2 unavailable")
+
+// Cgroup is the cgroup v2 directory a container is placed into
+type Cgroup struct {
+	Path string
+}
+
+func NewCgroup(id string) *Cgroup {
+	return &Cgroup{
+		Path: filepath.Join(cgroupBase(), id),
+	}
+}
+
+// cgroupBase is the directory container cgroups are created in. Root keeps
+// them below the hierarchy root, anyone else below the cgroup delegated to
+// them, the parent of their own. Their own holds processes, and the kernel
+// doesn't enable controllers below a cgroup that does
+func cgroupBase() string {
+	if os.Geteuid() == 0 {
+		return filepath.Join(cgroupRoot, cgroupParent)
+	}
+	own, err := processCgroup("self")
+	if err != nil || own == cgroupRoot {
+		return filepath.Join(cgroupRoot, cgroupParent)
+	}
+	return filepath.Join(filepath.Dir(own), cgroupParent)
+}
+
+// processCgroup returns the cgroup v2 directory a process is in
+func processCgroup(pid string) (string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	return "", fmt.Errorf("process %s is in no cgroup v2", pid)
+}
+
+// Create creates the cgroup directory and writes the resource limits into
+// it. Controllers that can't be enabled fail the limits that were asked
+// for, while default limits are left out and their controllers returned.
+// Errors wrapping errCgroupsUnavailable mean the container can only run
+// without a cgroup
+func (cg *Cgroup) Create(resources *ResourceConfig) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%w: cgroup v2 is not mounted at %s", errCgroupsUnavailable, cgroupRoot)
+	}
+
+	// Controllers have to be enabled on every level above the container
+	// cgroup before their interface files show up inside it
+	parent := filepath.Dir(cg.Path)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, cgroupError(fmt.Errorf("failed to create %s: %w", parent, err))
+	}
+	var skipped []string
+	for _, controller := range resources.controllers() {
+		err := enableController(filepath.Dir(parent), controller)
+		if err == nil {
+			err = enableController(parent, controller)
+		}
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, errCgroupsUnavailable) {
+			return nil, err
+		}
+		if slices.Contains(resources.Required, controller) {
+			return nil, fmt.Errorf("can't enforce the %s limit: %v", controller, err)
+		}
+		skipped = append(skipped, controller)
+	}
+	if len(skipped) > 0 {
+		resources = resources.without(skipped)
+	}
+
+	if err := os.Mkdir(cg.Path, 0755); err != nil {
+		return nil, cgroupError(fmt.Errorf("failed to create %s: %w", cg.Path, err))
+	}
+
+	if err := cg.apply(resources); err != nil {
+		cg.Remove()
+		return nil, err
+	}
+	return skipped, nil
+}
+
+// cgroupError marks errors about permissions and a busy hierarchy, such as
+// enabling controllers in a cgroup namespace whose root holds processes
+func cgroupError(err error) error {
+	for _, errno := range []syscall.Errno{syscall.EACCES, syscall.EPERM, syscall.EROFS, syscall.EBUSY} {
+		if errors.Is(err, errno) {
+			return fmt.Errorf("%w: %v", errCgroupsUnavailable, err)
+		}
+	}
+	return err
+}
+
+// controllers returns the cgroup controllers the limits need
+func (r *ResourceConfig) controllers() []string {
+	if r == nil {
+		return nil
+	}
+	var controllers []string
+	if r.CPUPeriod > 0 || r.CPUShare > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if r.CpusetCpus != "" || r.CpusetMems != "" {
+		controllers = append(controllers, "cpuset")
+	}
+	if len(r.IO) > 0 {
+		controllers = append(controllers, "io")
+	}
+	if r.Memory > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if r.PidsLimit > 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
+
+// without returns a copy of the limits leaving out those of controllers
+func (r ResourceConfig) without(controllers []string) *ResourceConfig {
+	for _, controller := range controllers {
+		switch controller {
+		case "cpu":
+			r.CPUPeriod, r.CPUQuota, r.CPUShare = 0, 0, 0
+		case "cpuset":
+			r.CpusetCpus, r.CpusetMems = "", ""
+		case "io":
+			r.IO = nil
+		case "memory":
+			r.Memory = 0
+		case "pids":
+			r.PidsLimit = 0
+		}
+	}
+	return &r
+}
+
+func (cg *Cgroup) apply(resources *ResourceConfig) error {
+	if resources == nil {
+		return nil
+	}
+
+	if resources.Memory > 0 {
+		if err := cg.write("memory.max", strconv.FormatInt(resources.Memory, 10)); err != nil {
+			return err
+		}
+	}
+
+	if resources.CPUPeriod > 0 {
+		quota := "max"
+		if resources.CPUQuota > 0 {
+			quota = strconv.FormatInt(resources.CPUQuota, 10)
+		}
+		if err := cg.write("cpu.max", fmt.Sprintf("%s %d", quota, resources.CPUPeriod)); err != nil {
+			return err
+		}
+	}
+
+	if resources.CPUShare > 0 {
+		if err := cg.write("cpu.weight", strconv.FormatInt(cpuSharesToWeight(resources.CPUShare), 10)); err != nil {
+			return err
+		}
+	}
+
+	if resources.PidsLimit > 0 {
+		if err := cg.write("pids.max", strconv.FormatInt(resources.PidsLimit, 10)); err != nil {
+			return err
+		}
+	}
+
+	// cpuset.mems has to be set before the cpus on some kernels, an empty
+	// value inherits the parent's set
+	if resources.CpusetMems != "" {
+		if err := cg.write("cpuset.mems", resources.CpusetMems); err != nil {
+			return err
+		}
+	}
+	if resources.CpusetCpus != "" {
+		if err := cg.write("cpuset.cpus", resources.CpusetCpus); err != nil {
+			return err
+		}
+	}
+
+	for _, limit := range resources.IO {
+		if err := cg.write("io.max", limit.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String formats the limit as an io.max line
+func (l IOLimit) String() string {
+	entry := fmt.Sprintf("%d:%d", l.Major, l.Minor)
+	for _, key := range []struct {
+		name  string
+		value uint64
+	}{
+		{"rbps", l.ReadBps},
+		{"wbps", l.WriteBps},
+		{"riops", l.ReadIOPS},
+		{"wiops", l.WriteIOPS},
+	} {
+		if key.value > 0 {
+			entry += fmt.Sprintf(" %s=%d", key.name, key.value)
+		}
+	}
+	return entry
+}
+
+// Remove deletes the cgroup. The kernel refuses while it still has
+// members, which can briefly be the case right after the container exits
+func (cg *Cgroup) Remove() error {
+	var err error
+	for i := 0; i < 10; i++ {
+		err = os.Remove(cg.Path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %v", cg.Path, err)
+}
+
+func (cg *Cgroup) write(file, value string) error {
+	if err := os.WriteFile(filepath.Join(cg.Path, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", file, err)
+	}
+	return nil
+}
+
+// enableController turns on controller in dir for its children, unless
+// it is on already, so nothing is written when the hierarchy is set up
+func enableController(dir, controller string) error {
+	subtree := filepath.Join(dir, "cgroup.subtree_control")
+	enabled, err := os.ReadFile(subtree)
+	if err != nil {
+		return cgroupError(fmt.Errorf("failed to read controllers of %s: %w", dir, err))
+	}
+	if slices.Contains(strings.Fields(string(enabled)), controller) {
+		return nil
+	}
+
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return cgroupError(fmt.Errorf("failed to read controllers of %s: %w", dir, err))
+	}
+	if !slices.Contains(strings.Fields(string(available)), controller) {
+		return fmt.Errorf("%w: the %s controller is not available in %s", errCgroupsUnavailable, controller, dir)
+	}
+
+	if err := os.WriteFile(subtree, []byte("+"+controller), 0644); err != nil {
+		return cgroupError(fmt.Errorf("failed to enable the %s controller in %s: %w", controller, dir, err))
+	}
+	return nil
+}
+
+// cpuSharesToWeight converts cgroup v1 cpu.shares [2, 262144] into the
+// cgroup v2 cpu.weight range [1, 10000]
+func cpuSharesToWeight(shares int64) int64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
@@ -2,47 +2,228 @@ package container
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/beltranaceves/gontainers/container/state"
 )
 
 type Container struct {
-	ID       string
-	Command  string
-	Args     []string
-	RootFS   string
-	Network  *Network
-	Resource *ResourceConfig
-	Pid      int
+	ID           string
+	Name         string
+	Image        string
+	ImageDigest  string // manifest Image resolved to when the container was created
+	Command      string
+	Args         []string
+	Hostname     string
+	Env          []string
+	WorkingDir   string
+	User         string // user[:group], by name or numeric id
+	RootFS       string
+	Filesystem   *Filesystem
+	Mounts       []Mount
+	Ports        []PortMapping
+	ExposedPorts []string // ports the image says it listens on, such as "80/tcp"
+	Network      *Network
+	Resource     *ResourceConfig
+	Log          LogConfig
+	Pid          int
+	StopSignal   string // signal Stop sends first, SIGTERM when empty
+
+	AutoRemove  bool // delete the container's files once it exits
+	Detach      bool
+	Interactive bool // keep stdin attached
+	Tty         bool
 }
 
 type ResourceConfig struct {
-	Memory    int64
-	CPUShare  int64
-	CPUPeriod int64
+	Memory    int64 // memory.max in bytes, 0 means unlimited
+	CPUShare  int64 // relative weight, converted to cpu.weight
+	CPUPeriod int64 // cpu.max period in microseconds
+	CPUQuota  int64 // cpu.max quota in microseconds, 0 means unlimited
+
+	PidsLimit  int64     // pids.max, 0 means unlimited
+	CpusetCpus string    // cpuset.cpus, e.g. "0-3" or "1,3"
+	CpusetMems string    // cpuset.mems, NUMA nodes to allocate memory from
+	IO         []IOLimit // io.max entries, one per block device
+
+	// Required are the controllers of limits that were asked for, which
+	// keep the container from starting when they can't be enforced. The
+	// defaults are dropped instead
+	Required []string
+}
+
+// IOLimit throttles a single block device. Zero values are left unlimited
+type IOLimit struct {
+	Major     int64
+	Minor     int64
+	ReadBps   uint64
+	WriteBps  uint64
+	ReadIOPS  uint64
+	WriteIOPS uint64
 }
 
 func NewContainer(command string, args []string) *Container {
 	return &Container{
-		ID:      generateID(),
-		Command: command,
-		Args:    args,
-		Resource: &ResourceConfig{
-			Memory:    512 * 1024 * 1024, // 512MB default
-			CPUShare:  1024,              // Default CPU share
-			CPUPeriod: 100000,
-		},
+		ID:       generateID(),
+		Command:  command,
+		Args:     args,
+		Resource: DefaultResourceConfig(),
+		Log:      DefaultLogConfig(),
+	}
+}
+
+func DefaultResourceConfig() *ResourceConfig {
+	return &ResourceConfig{
+		Memory:    512 * 1024 * 1024, // 512MB default
+		CPUShare:  1024,              // Default CPU share
+		CPUPeriod: 100000,
+	}
+}
+
+// DeviceIOLimit returns the io.max entry for the block device at path,
+// adding an unlimited one first if the device has none yet
+func (r *ResourceConfig) DeviceIOLimit(path string) (*IOLimit, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return nil, fmt.Errorf("failed to stat device %s: %v", path, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return nil, fmt.Errorf("%s is not a block device", path)
+	}
+
+	major := int64((stat.Rdev>>8)&0xfff | (stat.Rdev>>32)&^0xfff)
+	minor := int64(stat.Rdev&0xff | (stat.Rdev>>12)&^0xff)
+	for i := range r.IO {
+		if r.IO[i].Major == major && r.IO[i].Minor == minor {
+			return &r.IO[i], nil
+		}
 	}
+	r.IO = append(r.IO, IOLimit{Major: major, Minor: minor})
+	return &r.IO[len(r.IO)-1], nil
 }
 
 func generateID() string {
 	return fmt.Sprintf("gontainer-%d", time.Now().UnixNano())
 }
 
+// ShortID returns the container ID without the "gontainer-" prefix
+func (c *Container) ShortID() string {
+	return strings.TrimPrefix(c.ID, "gontainer-")
+}
+
+// defaultPath is used when neither the user nor the image set a PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Environ returns the environment the container command runs with
+func (c *Container) Environ() []string {
+	env := []string{
+		"PATH=" + defaultPath,
+		"HOSTNAME=" + c.Hostname,
+	}
+	if c.Tty {
+		env = append(env, "TERM=xterm")
+	}
+	return mergeEnv(env, c.Env)
+}
+
+// mergeEnv appends overrides to env, replacing variables that are already set
+func mergeEnv(env []string, overrides []string) []string {
+	merged := append([]string{}, env...)
+	for _, override := range overrides {
+		key, _, _ := strings.Cut(override, "=")
+		replaced := false
+		for i, variable := range merged {
+			if k, _, _ := strings.Cut(variable, "="); k == key {
+				merged[i] = override
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
+
+// Start runs the container in the foreground, wired to the caller's
+// stdio, and returns once it exits
 func (c *Container) Start() error {
+	if err := c.create(); err != nil {
+		return err
+	}
+
+	var stdin io.Reader
+	if c.Interactive {
+		stdin = os.Stdin
+	}
+
+	log, err := OpenLog(LogPath(c.ID), c.Log)
+	if err != nil {
+		return err
+	}
+	stdout, stderr := log.Stream("stdout"), log.Stream("stderr")
+	defer func() {
+		stdout.Flush()
+		stderr.Flush()
+		log.Close()
+	}()
+	return c.run(stdio{
+		stdin:    stdin,
+		stdout:   io.MultiWriter(os.Stdout, stdout),
+		stderr:   io.MultiWriter(os.Stderr, stderr),
+		terminal: hostTerminal(),
+	}, nil)
+}
+
+// create prepares everything the container needs and records it in the
+// store. Anything failing after this leaves a created container behind
+func (c *Container) create() error {
+	if c.Hostname == "" {
+		c.Hostname = c.ShortID()
+	}
+
+	store := state.Default()
+	if err := c.checkName(store); err != nil {
+		return err
+	}
+
+	if c.Image != "" && c.Filesystem == nil {
+		fs, err := c.SetupFilesystem()
+		if err != nil {
+			return err
+		}
+		if err := fs.Setup(); err != nil {
+			return fmt.Errorf("failed to set up filesystem: %v", err)
+		}
+		// Without overlayfs the container gets a copy of the image instead
+		if err := fs.CheckOverlay(); err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: overlayfs can't be mounted, copying the image: %v\n", err)
+			if err := FlattenImage(c.Image, fs.RootFS); err != nil {
+				return err
+			}
+			fs.Layers = nil
+		}
+	}
+
+	record, err := c.newState()
+	if err != nil {
+		return err
+	}
+	return store.Save(record)
+}
+
+// run starts the created container, waits for it and records how it
+// exited. started is called once the container is running
+func (c *Container) run(s stdio, started func()) error {
 
 	// TODO: DOCS
 	// /proc/self/exe is a symbolic link to the current process's executable
@@ -50,11 +231,20 @@ func (c *Container) Start() error {
 	// This is used to run the current process again as a child process
 	// with the "child" argument to start the "sandboxing" process
 
-	cmd := exec.Command("/proc/self/exe", append([]string{"child"}, os.Args[2:]...)...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	store := state.Default()
+
+	cmd := exec.Command("/proc/self/exe", append([]string{"child", c.Command}, c.Args...)...)
 	cmd.Env = []string{"GOCONTAINERS_CHILD=true"}
+
+	// The child reads its full configuration from a pipe, argv only carries
+	// the command so the container stays recognisable in the process list
+	configReader, configWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create config pipe: %v", err)
+	}
+	defer configReader.Close()
+	defer configWriter.Close()
+	cmd.ExtraFiles = []*os.File{configReader}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWPID |
 			syscall.CLONE_NEWNS |
@@ -62,109 +252,283 @@ func (c *Container) Start() error {
 			// syscall.CLONE_NEWIPC | // TODO: find out if this breaks too much stuff
 			syscall.CLONE_NEWUSER |
 			syscall.CLONE_NEWNET,
-		UidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0,
-				HostID:      os.Getuid(),
-				Size:        1,
-			},
-		},
-		GidMappings: []syscall.SysProcIDMap{
-			{
-				ContainerID: 0,
-				HostID:      os.Getuid(),
-				Size:        1,
-			},
-		},
-		Unshareflags: syscall.CLONE_NEWNS,
-	}
-
-	must(cmd.Run())
-
-	return nil
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWPID |
-			syscall.CLONE_NEWNS |
-			syscall.CLONE_NEWNET |
-			syscall.CLONE_NEWUTS,
+		UidMappings: idMappings(os.Getuid()),
+		GidMappings: idMappings(os.Getgid()),
+		// Only root may allow setgroups, which the child needs to drop the
+		// supplementary groups it inherited
+		GidMappingsEnableSetgroups: os.Getuid() == 0,
+		Unshareflags:               syscall.CLONE_NEWNS,
 	}
 
-	// Set the process name to include the container ID for easier identification
-	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	var con *console
+	if c.Tty {
+		con, err = newConsole()
+		if err != nil {
+			return err
+		}
+		defer con.Close()
+		con.Connect(cmd)
+	} else {
+		cmd.Stdin = s.stdin
+		cmd.Stdout = s.stdout
+		cmd.Stderr = s.stderr
+	}
 
-	// Use the configured rootfs
-	if c.RootFS != "" {
-		// Set the root directory for the container
-		cmd.Dir = c.RootFS
+	// Create the cgroup before the child exists and clone the child directly
+	// into it, so not a single instruction runs outside of the limits
+	cgroup := NewCgroup(c.ID)
+	skipped, err := cgroup.Create(c.Resource)
+	if errors.Is(err, errCgroupsUnavailable) && (c.Resource == nil || len(c.Resource.Required) == 0) {
+		fmt.Fprintf(os.Stderr, "WARNING: running without resource limits: %v\n", err)
+	} else if errors.Is(err, errCgroupsUnavailable) {
+		return fmt.Errorf("can't enforce the %s limits: %v", strings.Join(c.Resource.Required, ", "), err)
+	} else if err != nil {
+		return fmt.Errorf("failed to set up cgroup: %v", err)
+	} else {
+		defer cgroup.Remove()
+		if len(skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: running without the default %s limits, their controllers are not available\n", strings.Join(skipped, ", "))
+		}
 
-		// Optional: chroot to the rootfs (requires root privileges)
-		// This would require additional code to handle chroot
+		cgroupDir, err := os.Open(cgroup.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open cgroup: %v", err)
+		}
+		defer cgroupDir.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
 	}
 
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start container: %v", err)
 	}
+	c.Pid = cmd.Process.Pid
 
-	c.Pid = cmd.Process.Pid // Store the PID
+	if con != nil {
+		if err := con.Start(s); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+	}
 
-	// Store container info for later retrieval
-	if err := c.saveContainerInfo(); err != nil {
-		return fmt.Errorf("failed to save container info: %v", err)
+	configReader.Close()
+	if err := json.NewEncoder(configWriter).Encode(c); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to send config to container: %v", err)
 	}
+	configWriter.Close()
 
-	return nil
-}
+	err = store.Update(c.ID, func(s *state.State) error {
+		s.Pid = c.Pid
+		s.Started = time.Now()
+		return s.Transition(state.Running)
+	})
+	if err != nil {
+		cmd.Process.Kill()
+	} else if started != nil {
+		started()
+	}
+
+	cmd.Wait()
+	exitCode := exitStatus(cmd.ProcessState)
 
-func (c *Container) saveContainerInfo() error {
-	// Create directory inside the project
-	infoDir := "./containers"
-	if err := os.MkdirAll(infoDir, 0755); err != nil {
+	// stop or ps may have noticed the exit first and marked the container
+	// stopped already, the exit code is only known here though
+	store.Update(c.ID, func(s *state.State) error {
+		if s.Status != state.Stopped {
+			if err := s.Transition(state.Stopped); err != nil {
+				return err
+			}
+		}
+		s.ExitCode = exitCode
+		s.Finished = time.Now()
+		return nil
+	})
+
+	if c.AutoRemove {
+		store.Delete(c.ID)
+	}
+
+	if err != nil {
 		return err
 	}
+	if exitCode != 0 {
+		return &ExitError{Code: exitCode}
+	}
+	return nil
+}
+
+// ExitError reports a container command that exited with a non-zero status
+type ExitError struct {
+	Code int
+}
 
-	// Extract ID without the "gontainer-" prefix
-	idWithoutPrefix := c.ID
-	if len(c.ID) > 10 && c.ID[:10] == "gontainer-" {
-		idWithoutPrefix = c.ID[10:]
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container exited with status %d", e.Code)
+}
+
+// exitStatus follows the shell convention of 128+n for a process killed by signal n
+func exitStatus(ps *os.ProcessState) int {
+	if ps == nil {
+		return -1
+	}
+	if status, ok := ps.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
 	}
+	return ps.ExitCode()
+}
 
-	// Save basic container info to a file
-	infoPath := fmt.Sprintf("%s/%s.json", infoDir, idWithoutPrefix)
-	info := map[string]interface{}{
-		"id":      c.ID,
-		"command": c.Command,
-		"args":    c.Args,
-		"pid":     c.Pid,
-		"rootfs":  c.RootFS,
+// newState builds the record the store keeps for the container
+func (c *Container) newState() (*state.State, error) {
+	config, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode container config: %v", err)
 	}
 
-	// Convert to JSON
-	jsonData, err := json.Marshal(info)
+	record := state.New(c.ID)
+	record.Name = c.Name
+	record.Image = c.Image
+	record.ImageDigest = c.ImageDigest
+	record.Command = c.Command
+	record.Args = c.Args
+	record.Config = config
+	return record, nil
+}
+
+// checkName refuses names already taken by another container
+func (c *Container) checkName(store *state.Store) error {
+	if c.Name == "" {
+		return nil
+	}
+	records, err := store.List()
 	if err != nil {
 		return err
 	}
+	for _, record := range records {
+		if record.Name == c.Name {
+			return fmt.Errorf("the name %s is already in use by container %s", c.Name, record.ID)
+		}
+	}
+	return nil
+}
 
-	return os.WriteFile(infoPath, jsonData, 0644)
+// idMappings maps the container's ids onto the host. Root can hand out a
+// whole range so that -u works, everyone else only owns their own id
+func idMappings(hostID int) []syscall.SysProcIDMap {
+	if hostID == 0 {
+		return []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: 65536}}
+	}
+	return []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostID, Size: 1}}
 }
 
-func (c *Container) SetupFilesystem() *Filesystem {
-	// Create a unique root filesystem path for this container
-	rootPath := fmt.Sprintf("./containers/%s/rootfs", c.ID[10:])
-	fs := NewFilesystem(rootPath)
-	c.RootFS = rootPath
-	return fs
+// configFd is the descriptor the child finds its configuration pipe on,
+// the first one after stdin, stdout and stderr
+const configFd = 3
+
+// ReadConfig reads the configuration Start sends to the container process
+func ReadConfig() (*Container, error) {
+	pipe := os.NewFile(configFd, "config")
+	defer pipe.Close()
+
+	var c Container
+	if err := json.NewDecoder(pipe).Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to read container config: %v", err)
+	}
+	return &c, nil
 }
-func (c *Container) Kill() error {
-	// Implementation to kill the container process
-	return syscall.Kill(c.Pid, syscall.SIGTERM)
+
+// SetupFilesystem lays out the container's copy-on-write root: the image
+// layers stay read-only and every change lands in ./containers/<id>/upper
+func (c *Container) SetupFilesystem() (*Filesystem, error) {
+	digest, layers, err := ImageLayers(c.Image)
+	if err != nil {
+		return nil, err
+	}
+	c.ImageDigest = digest
+
+	containerDir, err := filepath.Abs(state.Default().Dir(c.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	fs := NewFilesystem(filepath.Join(containerDir, "rootfs"))
+	fs.Layers = layers
+	fs.UpperDir = filepath.Join(containerDir, "upper")
+	fs.WorkDir = filepath.Join(containerDir, "work")
+	c.RootFS = fs.RootFS
+	c.Filesystem = fs
+	return fs, nil
 }
 
-func must(err error) {
+// Kill sends a signal to the container's init process. The recorded status
+// is checked first, the PID of a container that exited may belong to an
+// unrelated process by now
+func (c *Container) Kill(signal syscall.Signal) error {
+	record, err := state.Default().Load(c.ID)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	if (record.Status != state.Running && record.Status != state.Paused) || !processAlive(record.Pid) {
+		return fmt.Errorf("container %s is not running", c.ID)
+	}
+	return syscall.Kill(record.Pid, signal)
+}
+
+// Stop asks the container to exit with its stop signal, SIGTERM by default,
+// and kills it once timeout has passed. The exit status is recorded by
+// whoever supervises the container, or here if nobody is left to do it
+func (c *Container) Stop(timeout time.Duration) error {
+	signal := syscall.SIGTERM
+	if c.StopSignal != "" {
+		var err error
+		if signal, err = ParseSignal(c.StopSignal); err != nil {
+			return err
+		}
+	}
+	if err := c.Kill(signal); err != nil {
+		return err
+	}
+
+	if !waitForExit(c.Pid, timeout) {
+		signal = syscall.SIGKILL
+		if err := syscall.Kill(c.Pid, signal); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to kill container %s: %v", c.ID, err)
+		}
+		waitForExit(c.Pid, -1)
+	}
+
+	// Give the supervisor a moment to record the real exit code
+	store := state.Default()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		record, err := store.Load(c.ID)
+		if err != nil || record.Status == state.Stopped {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return store.Update(c.ID, func(s *state.State) error {
+		if s.Status == state.Stopped {
+			return nil
+		}
+		s.ExitCode = 128 + int(signal)
+		s.Finished = time.Now()
+		return s.Transition(state.Stopped)
+	})
+}
+
+// waitForExit polls until pid is gone, forever when timeout is negative.
+// It reports whether the process exited in time
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid) {
+		if timeout >= 0 && time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
+	return true
 }
@@ -0,0 +1,136 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// nsenterEnv carries the PID whose namespaces the nsenter package joins
+// before the Go runtime starts. It has to match the C side
+const nsenterEnv = "GOCONTAINERS_NSENTER_PID"
+
+// Process is an additional command run inside an already running container
+type Process struct {
+	Command     string
+	Args        []string
+	Env         []string
+	WorkingDir  string
+	User        string
+	Interactive bool // keep stdin attached
+	Tty         bool
+}
+
+// Exec runs p inside the container's namespaces and cgroup and waits for it
+func (c *Container) Exec(p *Process) error {
+	if !processAlive(c.Pid) {
+		return fmt.Errorf("container %s is not running", c.ID)
+	}
+
+	// Unset options fall back to the ones the container was started with
+	process := *p
+	process.Env = c.Environ()
+	if process.Tty {
+		process.Env = mergeEnv(process.Env, []string{"TERM=xterm"})
+	}
+	process.Env = mergeEnv(process.Env, p.Env)
+	if process.User == "" {
+		process.User = c.User
+	}
+	if process.WorkingDir == "" {
+		process.WorkingDir = c.WorkingDir
+	}
+
+	var stdin io.Reader
+	if process.Interactive {
+		stdin = os.Stdin
+	}
+
+	cmd := exec.Command("/proc/self/exe", append([]string{"exec-child", process.Command}, process.Args...)...)
+	cmd.Env = []string{"GOCONTAINERS_CHILD=true", nsenterEnv + "=" + strconv.Itoa(c.Pid)}
+
+	configReader, configWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create config pipe: %v", err)
+	}
+	defer configReader.Close()
+	defer configWriter.Close()
+	cmd.ExtraFiles = []*os.File{configReader}
+
+	// The namespaces are joined by the child itself, the cgroup is joined
+	// the same way run does it, by cloning straight into it. Containers
+	// that run without limits have no cgroup of their own to join
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	if path, err := processCgroup(strconv.Itoa(c.Pid)); err == nil && filepath.Base(path) == c.ID {
+		cgroupDir, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open cgroup: %v", err)
+		}
+		defer cgroupDir.Close()
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
+
+	var con *console
+	if process.Tty {
+		con, err = newConsole()
+		if err != nil {
+			return err
+		}
+		defer con.Close()
+		con.Connect(cmd)
+	} else {
+		cmd.Stdin = stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start process: %v", err)
+	}
+
+	if con != nil {
+		if err := con.Start(stdio{stdin: stdin, stdout: os.Stdout, terminal: hostTerminal()}); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return err
+		}
+	}
+
+	configReader.Close()
+	if err := json.NewEncoder(configWriter).Encode(&process); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed to send config to process: %v", err)
+	}
+	configWriter.Close()
+
+	cmd.Wait()
+	if exitCode := exitStatus(cmd.ProcessState); exitCode != 0 {
+		return &ExitError{Code: exitCode}
+	}
+	return nil
+}
+
+// ExecInit runs inside the container's namespaces, which the nsenter
+// package joined before main, and replaces itself with the process
+func ExecInit() error {
+	if os.Getenv(nsenterEnv) != "" {
+		return fmt.Errorf("joining the container's namespaces requires a build with cgo enabled")
+	}
+
+	pipe := os.NewFile(configFd, "config")
+	defer pipe.Close()
+
+	var p Process
+	if err := json.NewDecoder(pipe).Decode(&p); err != nil {
+		return fmt.Errorf("failed to read process config: %v", err)
+	}
+
+	return execCommand(p.Command, p.Args, p.Env, p.User, p.WorkingDir)
+}
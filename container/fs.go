@@ -1,14 +1,31 @@
 package container
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/beltranaceves/gontainers/image"
 )
 
 type Filesystem struct {
-	RootFS string
-	Layers []string
+	RootFS   string
+	Layers   []string // read-only image layers, bottom one first, none when the image is flattened into RootFS
+	UpperDir string   // receives every change made by the container
+	WorkDir  string   // scratch space overlayfs needs on the upper's filesystem
+}
+
+// Mount is a host path bind mounted into the container
+type Mount struct {
+	Source      string
+	Destination string
+	ReadOnly    bool
 }
 
 func NewFilesystem(rootPath string) *Filesystem {
@@ -18,27 +35,284 @@ func NewFilesystem(rootPath string) *Filesystem {
 	}
 }
 
+// Setup creates the directories the overlay is assembled from
 func (fs *Filesystem) Setup() error {
-	if err := os.MkdirAll(fs.RootFS, 0755); err != nil {
-		return fmt.Errorf("failed to create rootfs: %v", err)
+	for _, dir := range []string{fs.RootFS, fs.UpperDir, fs.WorkDir} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dir, err)
+		}
 	}
+	return nil
+}
 
-	// Basic mount points
-	dirs := []string{
-		"proc",
-		"sys",
-		"dev",
-		"etc",
-		"bin",
-		"usr",
+// MountOverlay stacks the image layers under the container's upper directory
+// at RootFS. It runs inside the container's user namespace so rootless
+// containers work on kernels that allow unprivileged overlay mounts
+func (fs *Filesystem) MountOverlay() error {
+	if len(fs.Layers) == 0 {
+		return fmt.Errorf("no layers to mount")
 	}
 
-	for _, dir := range dirs {
-		path := filepath.Join(fs.RootFS, dir)
-		if err := os.MkdirAll(path, 0755); err != nil {
-			return fmt.Errorf("failed to create %s: %v", dir, err)
+	// overlayfs wants the topmost lower directory first
+	lowers := make([]string, len(fs.Layers))
+	for i, layer := range fs.Layers {
+		lowers[len(fs.Layers)-1-i] = layer
+	}
+
+	// Mount options have to fit in a single page, so layers sharing a parent
+	// directory are passed relative to it
+	if parent := commonParent(lowers); parent != "" {
+		if err := os.Chdir(parent); err != nil {
+			return fmt.Errorf("failed to chdir to %s: %v", parent, err)
+		}
+		for i, lower := range lowers {
+			lowers[i] = filepath.Base(lower)
 		}
 	}
 
+	// Even root is in a user namespace here, where overlayfs ignores
+	// trusted.* xattrs, so the layers mark opaque directories and the
+	// upper directory records its own with user.* ones
+	options := "lowerdir=" + strings.Join(lowers, ":")
+	if fs.UpperDir != "" {
+		options += ",upperdir=" + fs.UpperDir + ",workdir=" + fs.WorkDir
+	}
+	options += ",userxattr"
+
+	if err := syscall.Mount("overlay", fs.RootFS, "overlay", 0, options); err != nil {
+		return fmt.Errorf("failed to mount overlay: %v", err)
+	}
+	return nil
+}
+
+// CheckOverlay reports whether MountOverlay will work in the container, by
+// doing the same mount in throwaway user and mount namespaces. Kernels
+// without unprivileged overlayfs, or an upper directory on a filesystem
+// overlayfs can't write to, make it fail
+func (fs *Filesystem) CheckOverlay() error {
+	config, err := json.Marshal(fs)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/proc/self/exe", "overlay-check")
+	cmd.Stdin = bytes.NewReader(config)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings:                idMappings(os.Getuid()),
+		GidMappings:                idMappings(os.Getgid()),
+		GidMappingsEnableSetgroups: os.Getuid() == 0,
+	}
+	output, err := cmd.Output()
+	if err != nil && len(output) > 0 {
+		return errors.New(string(output))
+	}
+	return err
+}
+
+// OverlayCheck is the side of CheckOverlay inside the namespaces. It prints
+// why the mount failed rather than returning it
+func OverlayCheck() error {
+	var fs Filesystem
+	if err := json.NewDecoder(os.Stdin).Decode(&fs); err != nil {
+		return err
+	}
+	// The namespace goes away with this process, and the mount with it
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to make / private: %v", err)
+	}
+	if err := fs.MountOverlay(); err != nil {
+		fmt.Print(err)
+		os.Exit(1)
+	}
+	return nil
+}
+
+// commonParent returns the directory all paths are in, if there is one
+func commonParent(paths []string) string {
+	parent := filepath.Dir(paths[0])
+	for _, path := range paths[1:] {
+		if filepath.Dir(path) != parent {
+			return ""
+		}
+	}
+	return parent
+}
+
+// Apply bind mounts the source onto the destination below rootfs. It has
+// to run inside the container's mount namespace so the host never sees it
+func (m Mount) Apply(rootfs string) error {
+	info, err := os.Stat(m.Source)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %v", m.Source, err)
+	}
+	target, err := image.OpenInRoot(rootfs, m.Destination, info.IsDir())
+	if err != nil {
+		return fmt.Errorf("failed to create mountpoint %s: %v", m.Destination, err)
+	}
+	defer target.Close()
+
+	if err := syscall.Mount(m.Source, procFD(target), "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to mount %s on %s: %v", m.Source, m.Destination, err)
+	}
+
+	// Read-only has to be applied in a second step, MS_RDONLY is ignored
+	// when the bind mount is created. The descriptor still refers to what
+	// the bind mount covers, so the remount needs a fresh one
+	if m.ReadOnly {
+		mounted, err := image.OpenInRoot(rootfs, m.Destination, info.IsDir())
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", m.Destination, err)
+		}
+		defer mounted.Close()
+		flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | lockedFlags(procFD(mounted))
+		if err := syscall.Mount("", procFD(mounted), "", flags, ""); err != nil {
+			return fmt.Errorf("failed to make %s read-only: %v", m.Destination, err)
+		}
+	}
+	return nil
+}
+
+// mountDir mounts a filesystem on the directory at destination below
+// rootfs, creating it when it is missing
+func mountDir(rootfs, destination, source, fstype string, flags uintptr, data string) error {
+	target, err := image.OpenInRoot(rootfs, destination, true)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", destination, err)
+	}
+	defer target.Close()
+	if err := syscall.Mount(source, procFD(target), fstype, flags, data); err != nil {
+		return fmt.Errorf("failed to mount %s: %v", destination, err)
+	}
+	return nil
+}
+
+// procFD names the file f was opened on, for syscalls like mount(2) that
+// take no descriptor. Mount targets are opened below the rootfs and used
+// through it, so the image's symlinks are never followed on the host
+func procFD(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// lockedFlags returns the flags of the mount at path that a user namespace
+// is not allowed to drop on remount
+func lockedFlags(path string) uintptr {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0
+	}
+
+	// The ST_* statfs flags and the MS_* mount flags share their values
+	var flags uintptr
+	for _, flag := range []uintptr{syscall.MS_NOSUID, syscall.MS_NODEV, syscall.MS_NOEXEC, syscall.MS_NOATIME, syscall.MS_NODIRATIME} {
+		if uintptr(stat.Flags)&flag != 0 {
+			flags |= flag
+		}
+	}
+	return flags
+}
+
+// devices are bind mounted from the host since a user namespace can't mknod
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// PivotRoot turns RootFS into the root of the current mount namespace with
+// fresh /proc, /sys and /dev, mounting volumes on the way
+func (fs *Filesystem) PivotRoot(mounts []Mount) error {
+	// Keep every mount below from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to make / private: %v", err)
+	}
+
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(fs.RootFS, fs.RootFS, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind mount rootfs: %v", err)
+	}
+
+	if err := fs.mountProc(); err != nil {
+		return err
+	}
+	if err := fs.mountSys(); err != nil {
+		return err
+	}
+	if err := fs.mountDev(); err != nil {
+		return err
+	}
+
+	for _, mount := range mounts {
+		if err := mount.Apply(fs.RootFS); err != nil {
+			return err
+		}
+	}
+
+	// Stacking the old root on top of the new one with pivot_root(".", ".")
+	// avoids needing a writable directory to put it in
+	if err := os.Chdir(fs.RootFS); err != nil {
+		return fmt.Errorf("failed to chdir to rootfs: %v", err)
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to pivot_root: %v", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach old root: %v", err)
+	}
+	return os.Chdir("/")
+}
+
+func (fs *Filesystem) mountProc() error {
+	return mountDir(fs.RootFS, "/proc", "proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+}
+
+func (fs *Filesystem) mountSys() error {
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_RDONLY)
+	if err := mountDir(fs.RootFS, "/sys", "sysfs", "sysfs", flags, ""); err == nil {
+		return nil
+	}
+
+	// A fresh sysfs is refused when the host's /sys has mounts hiding parts
+	// of it, fall back to a read-only view of the host's
+	return Mount{Source: "/sys", Destination: "/sys", ReadOnly: true}.Apply(fs.RootFS)
+}
+
+func (fs *Filesystem) mountDev() error {
+	if err := mountDir(fs.RootFS, "/dev", "tmpfs", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		source := filepath.Join("/dev", device)
+		if _, err := os.Stat(source); err != nil {
+			continue
+		}
+		if err := (Mount{Source: source, Destination: filepath.Join("/dev", device)}).Apply(fs.RootFS); err != nil {
+			return err
+		}
+	}
+
+	if err := mountDir(fs.RootFS, "/dev/pts", "devpts", "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return err
+	}
+	if err := mountDir(fs.RootFS, "/dev/shm", "shm", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=1777,size=65536k"); err != nil {
+		return err
+	}
+
+	dev, err := image.OpenInRoot(fs.RootFS, "/dev", true)
+	if err != nil {
+		return fmt.Errorf("failed to open /dev: %v", err)
+	}
+	defer dev.Close()
+	links := map[string]string{
+		"ptmx":   "pts/ptmx",
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(procFD(dev), name)); err != nil {
+			return fmt.Errorf("failed to create /dev/%s: %v", name, err)
+		}
+	}
 	return nil
 }
@@ -0,0 +1,177 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/beltranaceves/gontainers/container/state"
+	"github.com/beltranaceves/gontainers/image"
+)
+
+// ImageLayers returns the digest of the manifest an image resolves to and
+// the absolute paths of its extracted layers, from the bottom one up
+func ImageLayers(name string) (string, []string, error) {
+	store := image.Default()
+	img, err := store.Resolve(name)
+	if err != nil {
+		return "", nil, err
+	}
+	layers, err := store.Layers(img)
+	if err != nil {
+		return "", nil, err
+	}
+	for i, layer := range layers {
+		if layers[i], err = filepath.Abs(layer); err != nil {
+			return "", nil, err
+		}
+	}
+	return img.Manifest.Digest, layers, nil
+}
+
+// FlattenImage extracts every layer of an image into dir, for when the
+// layers can't be stacked with overlayfs
+func FlattenImage(name, dir string) error {
+	store := image.Default()
+	img, err := store.Resolve(name)
+	if err != nil {
+		return err
+	}
+	return store.Flatten(img, dir)
+}
+
+// ImageConfig is the part of an OCI image config that describes how to
+// run the image
+type ImageConfig struct {
+	User         string              `json:"User"`
+	Env          []string            `json:"Env"`
+	Entrypoint   []string            `json:"Entrypoint"`
+	Cmd          []string            `json:"Cmd"`
+	WorkingDir   string              `json:"WorkingDir"`
+	StopSignal   string              `json:"StopSignal"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+}
+
+// ImageExists reports whether an image has been pulled
+func ImageExists(name string) bool {
+	_, err := image.Default().Resolve(name)
+	return err == nil
+}
+
+// ImageUsers returns the IDs of the containers created from an image.
+// Another reference to the same manifest keeps its blobs in the store, so
+// only containers created with this reference count
+func ImageUsers(name string) ([]string, error) {
+	img, err := image.Default().Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	records, err := state.Default().List()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, record := range records {
+		if record.Image == "" {
+			continue
+		}
+		if name, err := image.Name(record.Image); err == nil && name == img.Name {
+			ids = append(ids, record.ID)
+		}
+	}
+	return ids, nil
+}
+
+// UsedImages returns the digests of the manifests containers were created
+// from, which the image store has to keep even once they are untagged
+func UsedImages() ([]string, error) {
+	records, err := state.Default().List()
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []string
+	for _, record := range records {
+		digest := record.ImageDigest
+		// Records from before digests were kept only name the image
+		if digest == "" && record.Image != "" {
+			if img, err := image.Default().Resolve(record.Image); err == nil {
+				digest = img.Manifest.Digest
+			}
+		}
+		if digest != "" {
+			digests = append(digests, digest)
+		}
+	}
+	return digests, nil
+}
+
+// LoadImageConfig reads the config blob of an image from the image store
+func LoadImageConfig(name string) (*ImageConfig, error) {
+	store := image.Default()
+	img, err := store.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.Config(img)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config of %s: %v", name, err)
+	}
+
+	var file struct {
+		Config ImageConfig `json:"config"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config of %s: %v", name, err)
+	}
+	return &file.Config, nil
+}
+
+// ApplyImageConfig fills in whatever the user left unset from the image
+// config. The command given by the user replaces the image's Cmd, and
+// entrypoint, unless nil, replaces its Entrypoint and drops its Cmd
+func (c *Container) ApplyImageConfig(config *ImageConfig, entrypoint *string) error {
+	command := config.Entrypoint
+	defaultArgs := config.Cmd
+	if entrypoint != nil {
+		command = nil
+		if *entrypoint != "" {
+			command = []string{*entrypoint}
+		}
+		defaultArgs = nil
+	}
+
+	args := append([]string{}, c.Args...)
+	if c.Command != "" {
+		args = append([]string{c.Command}, args...)
+	} else {
+		args = append([]string{}, defaultArgs...)
+	}
+
+	argv := append(append([]string{}, command...), args...)
+	if len(argv) == 0 {
+		return fmt.Errorf("no command specified")
+	}
+	c.Command, c.Args = argv[0], argv[1:]
+
+	// Variables given by the user win over the image's
+	c.Env = mergeEnv(config.Env, c.Env)
+	if c.WorkingDir == "" {
+		c.WorkingDir = config.WorkingDir
+	}
+	if c.User == "" {
+		c.User = config.User
+	}
+	if c.StopSignal == "" {
+		c.StopSignal = config.StopSignal
+	}
+
+	c.ExposedPorts = nil
+	for port := range config.ExposedPorts {
+		c.ExposedPorts = append(c.ExposedPorts, port)
+	}
+	sort.Strings(c.ExposedPorts)
+	return nil
+}
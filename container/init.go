@@ -0,0 +1,112 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Init runs as the first process inside the container's namespaces. It
+// prepares the filesystem and the user and then replaces itself with the
+// container command, which becomes PID 1
+func Init() error {
+	c, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Sethostname([]byte(c.Hostname)); err != nil {
+		return fmt.Errorf("failed to set hostname: %v", err)
+	}
+
+	if c.Filesystem != nil {
+		// A flattened image is used as it is
+		if len(c.Filesystem.Layers) > 0 {
+			if err := c.Filesystem.MountOverlay(); err != nil {
+				return err
+			}
+		}
+
+		// The pseudo-terminal lives in the host's devpts, which is not
+		// visible after pivoting, so ttyname(3) can only find it as the
+		// console. Our stdin belongs to the parent's copy of the mount, the
+		// bind has to go through the path in this namespace
+		mounts := c.Mounts
+		if c.Tty {
+			slave, err := os.Readlink("/proc/self/fd/0")
+			if err != nil {
+				return fmt.Errorf("failed to find terminal: %v", err)
+			}
+			mounts = append(mounts, Mount{Source: slave, Destination: "/dev/console"})
+		}
+		if err := c.Filesystem.PivotRoot(mounts); err != nil {
+			return err
+		}
+	} else {
+		// Without an image the command runs on the host's filesystem
+		for _, mount := range c.Mounts {
+			if err := mount.Apply("/"); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Users are looked up after pivoting so the container's /etc/passwd is used
+	return execCommand(c.Command, c.Args, c.Environ(), c.User, c.WorkingDir)
+}
+
+// execCommand switches to the given user and working directory and replaces
+// the current process with command. It expects to already be inside the
+// container's filesystem
+func execCommand(command string, args []string, env []string, userSpec string, workingDir string) error {
+	user, err := LookupUser(userSpec)
+	if err != nil {
+		return err
+	}
+
+	if lookupEnv(env, "HOME") == "" {
+		env = append(env, "HOME="+user.Home)
+	}
+
+	if workingDir != "" {
+		if err := os.MkdirAll(workingDir, 0755); err != nil {
+			return fmt.Errorf("failed to create working directory: %v", err)
+		}
+		if err := os.Chdir(workingDir); err != nil {
+			return fmt.Errorf("failed to change to working directory: %v", err)
+		}
+	}
+
+	// exec.LookPath searches the PATH of the current process, not the
+	// container's, so borrow it for the lookup
+	os.Setenv("PATH", lookupEnv(env, "PATH"))
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return err
+	}
+
+	// Supplementary groups can only be dropped when the user namespace allows setgroups
+	if err := syscall.Setgroups(nil); err != nil && err != syscall.EPERM {
+		return fmt.Errorf("failed to drop supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("failed to set gid %d: %v", user.Gid, err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("failed to set uid %d: %v", user.Uid, err)
+	}
+
+	return syscall.Exec(path, append([]string{command}, args...), env)
+}
+
+// lookupEnv returns the value of key in a KEY=VALUE list
+func lookupEnv(env []string, key string) string {
+	for _, variable := range env {
+		if strings.HasPrefix(variable, key+"=") {
+			return strings.TrimPrefix(variable, key+"=")
+		}
+	}
+	return ""
+}
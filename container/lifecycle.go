@@ -0,0 +1,118 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container/state"
+)
+
+// Load finds a container in the store by its ID, its ID without the
+// "gontainer-" prefix or its name. Exact matches win, otherwise ref may be
+// a prefix of any of those as long as only one container matches
+func Load(ref string) (*Container, *state.State, error) {
+	if ref == "" {
+		return nil, nil, fmt.Errorf("no such container: %s", ref)
+	}
+
+	records, err := List()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, record := range records {
+		if record.ID == ref || record.ID == "gontainer-"+ref || (record.Name != "" && record.Name == ref) {
+			return fromState(record)
+		}
+	}
+
+	var matches []*state.State
+	for _, record := range records {
+		if strings.HasPrefix(record.ID, ref) || strings.HasPrefix(record.ID, "gontainer-"+ref) || (record.Name != "" && strings.HasPrefix(record.Name, ref)) {
+			matches = append(matches, record)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil, fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return fromState(matches[0])
+	default:
+		return nil, nil, fmt.Errorf("multiple containers match %s, use more characters", ref)
+	}
+}
+
+func fromState(record *state.State) (*Container, *state.State, error) {
+	var c Container
+	if err := json.Unmarshal(record.Config, &c); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode config of %s: %v", record.ID, err)
+	}
+	c.Pid = record.Pid
+	return &c, record, nil
+}
+
+// List returns every container in the store. Containers recorded as running
+// whose process is gone, because whoever supervised them died, are marked
+// stopped on the way
+func List() ([]*state.State, error) {
+	store := state.Default()
+	records, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if (record.Status != state.Running && record.Status != state.Paused) || processAlive(record.Pid) {
+			continue
+		}
+		store.Update(record.ID, func(s *state.State) error {
+			if s.Status != state.Running && s.Status != state.Paused {
+				return nil
+			}
+			s.Finished = time.Now()
+			return s.Transition(state.Stopped)
+		})
+		record.Status = state.Stopped
+	}
+	return records, nil
+}
+
+// Remove deletes a container and its files. Running containers are only
+// removed when force is set, after killing them
+func Remove(ref string, force bool) error {
+	c, record, err := Load(ref)
+	if err != nil {
+		return err
+	}
+
+	if record.Status == state.Running || record.Status == state.Paused {
+		if !force {
+			return fmt.Errorf("cannot remove running container %s, stop it first or force the removal", record.ID)
+		}
+		if err := syscall.Kill(c.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("failed to kill container %s: %v", record.ID, err)
+		}
+		waitForExit(c.Pid, -1)
+	}
+
+	store := state.Default()
+	store.Update(record.ID, func(s *state.State) error {
+		if s.Status == state.Running || s.Status == state.Paused {
+			s.Status = state.Stopped
+		}
+		return s.Transition(state.Removed)
+	})
+	return store.Delete(record.ID)
+}
+
+// processAlive reports whether pid still exists, signal 0 only checks
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,333 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container/state"
+)
+
+// LogConfig controls how much output is kept per container. The log is
+// rotated once it reaches MaxSize bytes, keeping MaxFiles files in total
+type LogConfig struct {
+	MaxSize  int64
+	MaxFiles int
+}
+
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		MaxSize:  10 * 1024 * 1024, // 10MB per file
+		MaxFiles: 3,
+	}
+}
+
+// LogEntry is a single line of output, stored one JSON object per line
+type LogEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// maxLineSize forces long output without newlines into several entries
+const maxLineSize = 16 * 1024
+
+// LogPath returns the current log file of a container, rotated files have
+// .1, .2, ... appended with the highest number being the oldest
+func LogPath(id string) string {
+	return filepath.Join(state.Default().Dir(id), "container.log")
+}
+
+// Log writes a container's streams into its log file
+type Log struct {
+	mu     sync.Mutex
+	path   string
+	config LogConfig
+	file   *os.File
+	size   int64
+}
+
+func OpenLog(path string, config LogConfig) (*Log, error) {
+	l := &Log{path: path, config: config}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *Log) write(entry LogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.MaxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.config.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts every file one number up, dropping the oldest
+func (l *Log) rotate() error {
+	l.file.Close()
+
+	if l.config.MaxFiles <= 1 {
+		os.Remove(l.path)
+		return l.open()
+	}
+
+	for i := l.config.MaxFiles - 1; i > 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i-1), fmt.Sprintf("%s.%d", l.path, i))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate log: %v", err)
+	}
+	return l.open()
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Stream returns a writer that logs every line written to it as the
+// named stream, such as "stdout" or "stderr"
+func (l *Log) Stream(name string) *LogStream {
+	return &LogStream{log: l, name: name}
+}
+
+// LogStream buffers partial lines until their newline arrives
+type LogStream struct {
+	log    *Log
+	name   string
+	buffer []byte
+}
+
+func (s *LogStream) Write(p []byte) (int, error) {
+	s.buffer = append(s.buffer, p...)
+	for {
+		i := bytes.IndexByte(s.buffer, '\n')
+		if i < 0 && len(s.buffer) < maxLineSize {
+			return len(p), nil
+		}
+		end := i + 1
+		if i < 0 {
+			end = maxLineSize
+		}
+		if err := s.log.write(LogEntry{Log: string(s.buffer[:end]), Stream: s.name, Time: time.Now().UTC()}); err != nil {
+			return len(p), err
+		}
+		s.buffer = s.buffer[end:]
+	}
+}
+
+// Flush logs a trailing line that never got its newline
+func (s *LogStream) Flush() error {
+	if len(s.buffer) == 0 {
+		return nil
+	}
+	err := s.log.write(LogEntry{Log: string(s.buffer), Stream: s.name, Time: time.Now().UTC()})
+	s.buffer = nil
+	return err
+}
+
+// LogOptions selects which entries ReadLogs returns
+type LogOptions struct {
+	Since  time.Time
+	Until  time.Time
+	Tail   int // only the last Tail entries, negative for all of them
+	Follow bool
+}
+
+// ReadLogs calls fn for the container's log entries, oldest first. With
+// Follow it keeps waiting for new entries until the container stops
+func ReadLogs(id string, options LogOptions, fn func(LogEntry) error) error {
+	path := LogPath(id)
+
+	accept := func(entry LogEntry) bool {
+		if !options.Since.IsZero() && entry.Time.Before(options.Since) {
+			return false
+		}
+		return options.Until.IsZero() || !entry.Time.After(options.Until)
+	}
+
+	// Rotated files come first, the highest number is the oldest
+	var files []string
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+
+	var entries []LogEntry
+	for _, file := range files {
+		if err := readLogFile(file, func(entry LogEntry) {
+			if accept(entry) {
+				entries = append(entries, entry)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	current, err := openLogReader(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() { current.Close() }()
+
+	current.readEntries(func(entry LogEntry) {
+		if accept(entry) {
+			entries = append(entries, entry)
+		}
+	})
+
+	if options.Tail >= 0 && len(entries) > options.Tail {
+		entries = entries[len(entries)-options.Tail:]
+	}
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	if !options.Follow {
+		return nil
+	}
+
+	var emitErr error
+	emit := func(entry LogEntry) {
+		if emitErr == nil && accept(entry) {
+			emitErr = fn(entry)
+		}
+	}
+
+	for {
+		current.readEntries(emit)
+		if emitErr != nil {
+			return emitErr
+		}
+		if !options.Until.IsZero() && time.Now().After(options.Until) {
+			return nil
+		}
+
+		// The writer rotated the file underneath us, finish the old one
+		// and carry on with the new
+		if rotated(current.file, path) {
+			current.readEntries(emit)
+			next, err := openLogReader(path)
+			if err != nil {
+				return err
+			}
+			current.Close()
+			current = next
+			continue
+		}
+
+		record, err := state.Default().Load(id)
+		if err != nil || (record.Status != state.Running && record.Status != state.Paused) {
+			current.readEntries(emit)
+			return emitErr
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func readLogFile(path string, fn func(LogEntry)) error {
+	reader, err := openLogReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	reader.readEntries(fn)
+	return nil
+}
+
+// logReader decodes entries from a log file that may still be growing
+type logReader struct {
+	file    *os.File
+	pending []byte
+}
+
+func openLogReader(path string) (*logReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &logReader{file: file}, nil
+}
+
+// readEntries decodes every complete line available right now. A line
+// without its newline is still being written and is kept for the next call
+func (r *logReader) readEntries(fn func(LogEntry)) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.file.Read(buf)
+		r.pending = append(r.pending, buf[:n]...)
+		for {
+			i := bytes.IndexByte(r.pending, '\n')
+			if i < 0 {
+				break
+			}
+			var entry LogEntry
+			if json.Unmarshal(r.pending[:i], &entry) == nil {
+				fn(entry)
+			}
+			r.pending = r.pending[i+1:]
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+func (r *logReader) Close() error {
+	return r.file.Close()
+}
+
+// rotated reports whether path is no longer the file that is open
+func rotated(file *os.File, path string) bool {
+	var open, current syscall.Stat_t
+	if err := syscall.Fstat(int(file.Fd()), &open); err != nil {
+		return false
+	}
+	if err := syscall.Stat(path, &current); err != nil {
+		return false
+	}
+	return open.Ino != current.Ino
+}
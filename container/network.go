@@ -12,6 +12,14 @@ type Network struct {
 	Interface string
 }
 
+// PortMapping publishes a container port on the host
+type PortMapping struct {
+	HostIP        string
+	HostPort      int
+	ContainerPort int
+	Protocol      string
+}
+
 func NewNetwork(name string) *Network {
 	return &Network{
 		Name:    name,
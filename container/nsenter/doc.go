@@ -0,0 +1,11 @@
+// Package nsenter joins the namespaces of a running container before the
+// Go runtime starts. setns refuses user and mount namespaces once a
+// process has more than one thread, which a Go program always has by the
+// time main runs, so this has to happen in a C constructor.
+//
+// Importing the package is enough. When GOCONTAINERS_NSENTER_PID is set the
+// constructor joins every namespace of that process that differs from its
+// own, forks so the new PID namespace takes effect, and lets the child
+// continue into the Go program while the parent waits and passes on its
+// exit status.
+package nsenter
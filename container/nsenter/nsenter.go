@@ -0,0 +1,112 @@
+//go:build linux && cgo
+
+package nsenter
+
+/*
+#define _GNU_SOURCE
+#include <errno.h>
+#include <fcntl.h>
+#include <sched.h>
+#include <signal.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/prctl.h>
+#include <sys/stat.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+static pid_t child;
+
+static void fail(const char *what, const char *namespace)
+{
+	fprintf(stderr, "nsenter: %s %s namespace: %s\n", what, namespace, strerror(errno));
+	exit(1);
+}
+
+static void forward(int signal)
+{
+	if (child > 0)
+		kill(child, signal);
+}
+
+__attribute__((constructor)) static void nsenter(void)
+{
+	// The user namespace goes first so the others, which it owns, can be
+	// joined with its capabilities, the mount namespace last since it
+	// changes what /proc refers to
+	static const struct {
+		const char *name;
+		int type;
+	} namespaces[] = {
+		{"user", CLONE_NEWUSER},
+		{"ipc", CLONE_NEWIPC},
+		{"uts", CLONE_NEWUTS},
+		{"net", CLONE_NEWNET},
+		{"pid", CLONE_NEWPID},
+		{"mnt", CLONE_NEWNS},
+	};
+	enum { count = sizeof(namespaces) / sizeof(namespaces[0]) };
+
+	const char *pid = getenv("GOCONTAINERS_NSENTER_PID");
+	if (pid == NULL)
+		return;
+
+	// Every namespace is opened before joining any of them. Joining the
+	// one we are already in fails for user namespaces, so those are skipped
+	int fds[count];
+	for (int i = 0; i < count; i++) {
+		char path[64];
+		struct stat own, target;
+
+		snprintf(path, sizeof(path), "/proc/self/ns/%s", namespaces[i].name);
+		if (stat(path, &own) < 0)
+			fail("failed to stat own", namespaces[i].name);
+		snprintf(path, sizeof(path), "/proc/%s/ns/%s", pid, namespaces[i].name);
+		if (stat(path, &target) < 0)
+			fail("failed to stat container", namespaces[i].name);
+
+		fds[i] = -1;
+		if (own.st_dev == target.st_dev && own.st_ino == target.st_ino)
+			continue;
+		fds[i] = open(path, O_RDONLY | O_CLOEXEC);
+		if (fds[i] < 0)
+			fail("failed to open", namespaces[i].name);
+	}
+
+	for (int i = 0; i < count; i++) {
+		if (fds[i] < 0)
+			continue;
+		if (setns(fds[i], namespaces[i].type) < 0)
+			fail("failed to join", namespaces[i].name);
+		close(fds[i]);
+	}
+	unsetenv("GOCONTAINERS_NSENTER_PID");
+
+	// Only children are created in the new PID namespace
+	child = fork();
+	if (child < 0)
+		fail("failed to fork into", "pid");
+	if (child == 0) {
+		prctl(PR_SET_PDEATHSIG, SIGKILL);
+		return;
+	}
+
+	struct sigaction action = {0};
+	action.sa_handler = forward;
+	int forwarded[] = {SIGHUP, SIGINT, SIGQUIT, SIGTERM, SIGUSR1, SIGUSR2, SIGWINCH};
+	for (int i = 0; i < (int)(sizeof(forwarded) / sizeof(forwarded[0])); i++)
+		sigaction(forwarded[i], &action, NULL);
+
+	int status;
+	while (waitpid(child, &status, 0) < 0) {
+		if (errno != EINTR)
+			exit(1);
+	}
+	if (WIFSIGNALED(status))
+		exit(128 + WTERMSIG(status));
+	exit(WEXITSTATUS(status));
+}
+*/
+import "C"
@@ -0,0 +1,142 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/beltranaceves/gontainers/container/state"
+)
+
+// readyFd is where the supervisor reports back whether the container started
+const readyFd = 3
+
+// StartDetached creates the container and hands it over to a supervisor
+// process that outlives the caller. It returns as soon as the container runs
+func (c *Container) StartDetached() error {
+	if err := c.create(); err != nil {
+		return err
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create ready pipe: %v", err)
+	}
+	defer readyReader.Close()
+
+	// Whatever the supervisor itself prints ends up next to the container state
+	logPath := filepath.Join(state.Default().Dir(c.ID), "shim.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		readyWriter.Close()
+		return fmt.Errorf("failed to open supervisor log: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("/proc/self/exe", "shim", c.ID)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	// A session of its own keeps the supervisor alive when the terminal
+	// that started it goes away
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start supervisor: %v", err)
+	}
+	defer cmd.Process.Release()
+
+	message, _ := io.ReadAll(readyReader)
+	switch string(message) {
+	case "ok":
+		return nil
+	case "":
+		return fmt.Errorf("supervisor exited before the container started, see %s", logPath)
+	default:
+		return errors.New(string(message))
+	}
+}
+
+// Supervise is the body of the supervisor process of a detached container.
+// It holds the container's stdio, serves it to attached clients, reaps
+// the container and records its exit code
+func Supervise(id string) error {
+	ready := os.NewFile(readyFd, "ready")
+	syscall.CloseOnExec(readyFd)
+
+	record, err := state.Default().Load(id)
+	if err != nil {
+		fmt.Fprint(ready, err)
+		ready.Close()
+		return err
+	}
+	c, _, err := fromState(record)
+	if err != nil {
+		fmt.Fprint(ready, err)
+		ready.Close()
+		return err
+	}
+
+	// An interactive container keeps an open stdin even without anyone
+	// attached, it just never receives any input
+	var stdin io.Reader
+	var stdinWriter io.Writer
+	if c.Interactive {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			fmt.Fprint(ready, err)
+			ready.Close()
+			return err
+		}
+		defer writer.Close()
+		stdin, stdinWriter = reader, writer
+	}
+
+	// Attached clients share the container's output and input
+	attach, err := listenAttach(c.ID, stdinWriter)
+	if err != nil {
+		fmt.Fprint(ready, err)
+		ready.Close()
+		return err
+	}
+	defer attach.Close()
+
+	log, err := OpenLog(LogPath(c.ID), c.Log)
+	if err != nil {
+		fmt.Fprint(ready, err)
+		ready.Close()
+		return err
+	}
+	stdout, stderr := log.Stream("stdout"), log.Stream("stderr")
+	defer func() {
+		stdout.Flush()
+		stderr.Flush()
+		log.Close()
+	}()
+
+	started := false
+	err = c.run(stdio{
+		stdin:  stdin,
+		stdout: io.MultiWriter(stdout, attach.Stream(streamStdout)),
+		stderr: io.MultiWriter(stderr, attach.Stream(streamStderr)),
+		resize: attach.resize,
+	}, func() {
+		started = true
+		ready.Write([]byte("ok"))
+		ready.Close()
+	})
+	if !started {
+		if err == nil {
+			err = fmt.Errorf("container exited before it started")
+		}
+		fmt.Fprint(ready, err)
+		ready.Close()
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+// Package state persists the lifecycle of containers across gontainers
+// invocations. Every container has a directory below the store root holding
+// a versioned state.json, replaced atomically on every change
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version is the format of the state records written by this package
+const Version = 1
+
+type Status string
+
+const (
+	Created Status = "created"
+	Running Status = "running"
+	Paused  Status = "paused"
+	Stopped Status = "stopped"
+	Removed Status = "removed"
+)
+
+// transitions lists the statuses a container can move to from each status
+var transitions = map[Status][]Status{
+	Created: {Running, Stopped, Removed},
+	Running: {Paused, Stopped},
+	Paused:  {Running, Stopped},
+	Stopped: {Removed},
+}
+
+// State is the persisted record of a single container
+type State struct {
+	Version     int       `json:"version"`
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	ImageDigest string    `json:"image_digest,omitempty"` // manifest Image pointed to, kept in the image store while the record exists
+	Command     string    `json:"command"`
+	Args        []string  `json:"args,omitempty"`
+	Pid         int       `json:"pid,omitempty"`
+	Status      Status    `json:"status"`
+	ExitCode    int       `json:"exit_code"`
+	Created     time.Time `json:"created"`
+	Started     time.Time `json:"started,omitempty"`
+	Finished    time.Time `json:"finished,omitempty"`
+
+	// Config is the full container configuration. It is owned by the
+	// container package and opaque to the store
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+func New(id string) *State {
+	return &State{
+		Version: Version,
+		ID:      id,
+		Status:  Created,
+		Created: time.Now(),
+	}
+}
+
+// Transition moves the container to another status, refusing moves the
+// lifecycle doesn't allow such as restarting a removed container
+func (s *State) Transition(to Status) error {
+	for _, allowed := range transitions[s.Status] {
+		if allowed == to {
+			s.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("container %s can't go from %s to %s", s.ID, s.Status, to)
+}
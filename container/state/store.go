@@ -0,0 +1,157 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	stateFile = "state.json"
+	lockFile  = "state.lock"
+)
+
+// Store keeps container state below Root/containers/<id>
+type Store struct {
+	Root string
+}
+
+func NewStore(root string) *Store {
+	return &Store{Root: root}
+}
+
+// Default returns the store at DefaultRoot
+func Default() *Store {
+	return NewStore(DefaultRoot())
+}
+
+// DefaultRoot is $GONTAINERS_ROOT when set, otherwise gontainers below
+// $XDG_DATA_HOME, which itself defaults to ~/.local/share
+func DefaultRoot() string {
+	if root := os.Getenv("GONTAINERS_ROOT"); root != "" {
+		return root
+	}
+	if data := os.Getenv("XDG_DATA_HOME"); data != "" {
+		return filepath.Join(data, "gontainers")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/var/lib"
+	}
+	return filepath.Join(home, ".local", "share", "gontainers")
+}
+
+// Dir returns the directory holding everything that belongs to a container
+func (s *Store) Dir(id string) string {
+	return filepath.Join(s.Root, "containers", id)
+}
+
+// Save writes the state to a temporary file and renames it over the
+// previous one, so readers never see a partially written record
+func (s *Store) Save(state *State) error {
+	dir := s.Dir(state.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, stateFile+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, stateFile)); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
+
+func (s *Store) Load(id string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir(id), stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such container: %s", id)
+		}
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state of %s: %v", id, err)
+	}
+	if state.Version > Version {
+		return nil, fmt.Errorf("state of %s has version %d, newer than the supported %d", id, state.Version, Version)
+	}
+	return &state, nil
+}
+
+// List returns the state of every container, skipping unreadable records
+func (s *Store) List() ([]*State, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Root, "containers"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var states []*State
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		state, err := s.Load(entry.Name())
+		if err != nil {
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Update loads, modifies and saves a state while holding the container's
+// lock, so concurrent gontainers processes don't overwrite each other
+func (s *Store) Update(id string, update func(state *State) error) error {
+	lock, err := os.OpenFile(filepath.Join(s.Dir(id), lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to lock state of %s: %v", id, err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock state of %s: %v", id, err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	state, err := s.Load(id)
+	if err != nil {
+		return err
+	}
+	if err := update(state); err != nil {
+		return err
+	}
+	return s.Save(state)
+}
+
+// Delete removes the container's directory and everything in it
+func (s *Store) Delete(id string) error {
+	return os.RemoveAll(s.Dir(id))
+}
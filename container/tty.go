@@ -0,0 +1,163 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/beltranaceves/gontainers/term"
+)
+
+// stdio is what a container's streams are connected to. A container
+// pseudo-terminal follows the size of terminal, or whatever arrives on
+// resize when there is no terminal
+type stdio struct {
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+	terminal *os.File
+	resize   <-chan term.Winsize // sizes requested by attached clients
+}
+
+// hostTerminal returns stdin when it is a terminal, otherwise nil
+func hostTerminal() *os.File {
+	if term.IsTerminal(os.Stdin) {
+		return os.Stdin
+	}
+	return nil
+}
+
+// console is a pseudo-terminal pair. The slave becomes the controlling
+// terminal of the container, the master is copied from and to the host
+type console struct {
+	master *os.File
+	slave  *os.File
+
+	output   chan struct{}
+	terminal *os.File
+	restore  *syscall.Termios
+	resize   chan os.Signal
+}
+
+func newConsole() (*console, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pseudo-terminal: %v", err)
+	}
+
+	var unlock int32
+	if err := term.Ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to unlock pseudo-terminal: %v", err)
+	}
+	var number uint32
+	if err := term.Ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to get pseudo-terminal number: %v", err)
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to open pseudo-terminal: %v", err)
+	}
+	return &console{master: master, slave: slave}, nil
+}
+
+// Connect makes the slave the standard streams and controlling terminal of cmd
+func (con *console) Connect(cmd *exec.Cmd) {
+	cmd.Stdin = con.slave
+	cmd.Stdout = con.slave
+	cmd.Stderr = con.slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// A new session without a terminal, then stdin becomes its terminal
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+}
+
+// Start copies between the master and s once the command has started.
+// With an attached host terminal it is put into raw mode so every key
+// reaches the container, and window size changes are passed on
+func (con *console) Start(s stdio) error {
+	// Holding on to the slave would keep the master from ever seeing the end
+	con.slave.Close()
+
+	if s.terminal != nil {
+		con.terminal = s.terminal
+		con.resize = make(chan os.Signal, 1)
+		signal.Notify(con.resize, syscall.SIGWINCH)
+		con.resize <- syscall.SIGWINCH
+		go func() {
+			for range con.resize {
+				con.Resize(s.terminal)
+			}
+		}()
+
+		if s.stdin != nil {
+			restore, err := term.MakeRaw(s.terminal)
+			if err != nil {
+				return fmt.Errorf("failed to put terminal into raw mode: %v", err)
+			}
+			con.restore = restore
+		}
+	}
+
+	if s.resize != nil {
+		go func() {
+			for size := range s.resize {
+				con.setSize(size)
+			}
+		}()
+	}
+
+	if s.stdin != nil {
+		go io.Copy(con.master, s.stdin)
+	}
+	con.output = make(chan struct{})
+	go func() {
+		io.Copy(s.stdout, con.master)
+		close(con.output)
+	}()
+	return nil
+}
+
+// Resize gives the pseudo-terminal the size of terminal
+func (con *console) Resize(terminal *os.File) error {
+	size, err := term.GetWinsize(terminal)
+	if err != nil {
+		return err
+	}
+	return con.setSize(size)
+}
+
+func (con *console) setSize(size term.Winsize) error {
+	return term.SetWinsize(con.master, size)
+}
+
+// Close waits for the remaining output and restores the host terminal
+func (con *console) Close() error {
+	if con.output != nil {
+		// Background processes may keep the slave open, so do not wait forever
+		select {
+		case <-con.output:
+		case <-time.After(time.Second):
+		}
+	}
+	if con.resize != nil {
+		signal.Stop(con.resize)
+		close(con.resize)
+	}
+	if con.restore != nil {
+		term.SetTermios(con.terminal, con.restore)
+	}
+	con.slave.Close()
+	return con.master.Close()
+}
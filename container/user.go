@@ -0,0 +1,85 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ExecUser is the identity the container command runs as
+type ExecUser struct {
+	Uid  int
+	Gid  int
+	Home string
+}
+
+// LookupUser resolves a user[:group] spec against /etc/passwd and
+// /etc/group. It runs inside the container so the names come from its
+// filesystem, not the host's
+func LookupUser(spec string) (*ExecUser, error) {
+	user := &ExecUser{Home: "/"}
+	if spec == "" {
+		spec = "0"
+	}
+	userSpec, groupSpec, hasGroup := strings.Cut(spec, ":")
+
+	found := false
+	for _, entry := range readDatabase("/etc/passwd") {
+		// name:password:uid:gid:gecos:home:shell
+		if len(entry) < 6 || (entry[0] != userSpec && entry[2] != userSpec) {
+			continue
+		}
+		user.Uid, _ = strconv.Atoi(entry[2])
+		user.Gid, _ = strconv.Atoi(entry[3])
+		user.Home = entry[5]
+		found = true
+		break
+	}
+	if !found {
+		uid, err := strconv.Atoi(userSpec)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userSpec)
+		}
+		user.Uid = uid
+		user.Gid = uid
+	}
+
+	if !hasGroup {
+		return user, nil
+	}
+	for _, entry := range readDatabase("/etc/group") {
+		// name:password:gid:members
+		if len(entry) >= 3 && (entry[0] == groupSpec || entry[2] == groupSpec) {
+			user.Gid, _ = strconv.Atoi(entry[2])
+			return user, nil
+		}
+	}
+	gid, err := strconv.Atoi(groupSpec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupSpec)
+	}
+	user.Gid = gid
+	return user, nil
+}
+
+// readDatabase splits a colon separated file like /etc/passwd into fields
+func readDatabase(path string) [][]string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var entries [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries
+}
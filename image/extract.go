@@ -0,0 +1,498 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/beltranaceves/gontainers/registry"
+	"github.com/klauspost/compress/zstd"
+)
+
+// extractLayer extracts a layer tarball of the given media type to the
+// given directory. Flattened, the layer is applied on top of the ones
+// already extracted there and its whiteouts remove their files. Otherwise
+// the directory holds just this layer and whiteouts are kept the way
+// overlayfs expects them
+func extractLayer(layerPath, mediaType, rootfsDir string, flatten bool) error {
+	decompress, err := decompressor(mediaType)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(layerPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := decompress(file)
+	if err != nil {
+		return fmt.Errorf("failed to decompress layer: %v", err)
+	}
+	defer reader.Close()
+
+	return extractTar(reader, rootfsDir, flatten)
+}
+
+// decompressor returns what turns a layer of the given media type into a
+// tar stream. Unknown media types and layers that are not distributed by
+// registries are errors, rather than something to guess at
+func decompressor(mediaType string) (func(io.Reader) (io.ReadCloser, error), error) {
+	switch mediaType {
+	case registry.MediaTypeOCILayer:
+		return func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		}, nil
+	case registry.MediaTypeOCILayerGzip, registry.MediaTypeDockerLayer:
+		return func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}, nil
+	case registry.MediaTypeOCILayerZstd:
+		return func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		}, nil
+	}
+
+	if strings.HasPrefix(mediaType, registry.MediaTypeOCINondistributableLayer) || mediaType == registry.MediaTypeDockerForeignLayer {
+		return nil, fmt.Errorf("non-distributable layers are not supported: %s", mediaType)
+	}
+	return nil, fmt.Errorf("unsupported layer media type %q", mediaType)
+}
+
+// extractTar extracts a tar archive to the specified directory. Layers
+// come from third parties, so every entry is created through a root that
+// keeps it inside destDir, and entries that try to leave it fail the
+// extraction
+func extractTar(reader io.Reader, destDir string, flatten bool) error {
+	root, err := openRoot(destDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	x := &extractor{
+		root:      root,
+		rootless:  os.Geteuid() != 0,
+		flatten:   flatten,
+		extracted: map[string]bool{".": true},
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := cleanName(header.Name)
+		if err != nil {
+			return fmt.Errorf("invalid layer entry: %v", err)
+		}
+		// Skip the "." directory entry
+		if name == "." {
+			continue
+		}
+
+		if err := x.extract(name, header, tarReader); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", header.Name, err)
+		}
+	}
+
+	// A whiteout hides what lower layers have, never what its own layer
+	// has, wherever in the layer it comes
+	for _, name := range x.whiteouts {
+		if err := x.applyWhiteout(name); err != nil {
+			return fmt.Errorf("failed to apply whiteout for %s: %v", name, err)
+		}
+	}
+	for _, dir := range x.opaques {
+		if err := x.applyOpaque(dir); err != nil {
+			return fmt.Errorf("failed to apply opaque whiteout for %s: %v", dir, err)
+		}
+	}
+
+	// Creating entries in a directory changes its mtime, and a read-only
+	// mode would keep them from being created at all, so directories get
+	// both once everything in them exists
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		if err := x.finishDir(x.dirs[i]); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", x.dirs[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Whiteouts are entries named after what they remove with whiteoutPrefix
+// in front. An opaqueWhiteout hides everything below its directory. Other
+// names starting with whiteoutMetaPrefix are reserved
+const (
+	whiteoutPrefix     = ".wh."
+	whiteoutMetaPrefix = ".wh..wh."
+	opaqueWhiteout     = ".wh..wh..opq"
+)
+
+// overrideStatXattr records the owner and mode an entry should have when
+// the extraction could not give it to the entry itself, in the format
+// fuse-overlayfs and containers/storage use
+const overrideStatXattr = "user.containers.override_stat"
+
+// extractor creates the entries of one layer below root
+type extractor struct {
+	root     *root
+	rootless bool          // unprivileged, so ownership and devices can't be restored
+	dirs     []*tar.Header // directories whose mode and times are set last
+
+	flatten   bool            // apply whiteouts rather than keep them for overlayfs
+	extracted map[string]bool // every path this layer has put something at
+	whiteouts []string        // paths hidden from lower layers
+	opaques   []string        // directories hidden from lower layers
+}
+
+// extract creates the file, directory, link, device or fifo a tar header
+// describes at name, replacing whatever is there, and restores its owner,
+// mode, extended attributes and times
+func (x *extractor) extract(name string, header *tar.Header, r io.Reader) error {
+	if base := path.Base(name); strings.HasPrefix(base, whiteoutPrefix) {
+		return x.whiteout(name)
+	}
+	// Parents count as well, the layer needs them to hold the entry
+	for p := name; p != "." && !x.extracted[p]; p = path.Dir(p) {
+		x.extracted[p] = true
+	}
+
+	parent, base, err := x.root.parent(name, true)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	dirfd := int(parent.Fd())
+	mode := uint32(header.Mode) & 07777
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		// Directories are merged with existing ones, anything else is replaced
+		if existing, err := lstat(parent, base); err == nil && existing&syscall.S_IFMT != syscall.S_IFDIR {
+			if err := remove(parent, base); err != nil {
+				return err
+			}
+		}
+		if err := syscall.Mkdirat(dirfd, base, 0700); err != nil && err != syscall.EEXIST {
+			return err
+		}
+		x.dirs = append(x.dirs, header)
+
+	case tar.TypeReg:
+		if err := remove(parent, base); err != nil {
+			return err
+		}
+		// O_NOFOLLOW and O_EXCL so nothing but a new file is written to
+		fd, err := syscall.Openat(dirfd, base, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0600)
+		if err != nil {
+			return err
+		}
+		file := os.NewFile(uintptr(fd), name)
+		_, err = io.Copy(file, r)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		// The target is only resolved when the link is used, inside the container
+		if err := remove(parent, base); err != nil {
+			return err
+		}
+		if err := symlinkat(header.Linkname, parent, base); err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		target, err := cleanName(header.Linkname)
+		if err != nil {
+			return fmt.Errorf("invalid link target: %v", err)
+		}
+		targetParent, targetBase, err := x.root.parent(target, false)
+		if err != nil {
+			return err
+		}
+		defer targetParent.Close()
+		if err := remove(parent, base); err != nil {
+			return err
+		}
+		// A hard link shares everything with its target, which is already restored
+		return linkat(targetParent, targetBase, parent, base)
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := remove(parent, base); err != nil {
+			return err
+		}
+		if x.rootless && header.Typeflag != tar.TypeFifo {
+			// Creating devices takes CAP_MKNOD in the initial user namespace,
+			// an empty file with the device recorded in the xattr stands in
+			fd, err := syscall.Openat(dirfd, base, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0600)
+			if err != nil {
+				return err
+			}
+			syscall.Close(fd)
+			break
+		}
+		if err := syscall.Mknodat(dirfd, base, fileType(header.Typeflag)|0600, mkdev(header.Devmajor, header.Devminor)); err != nil {
+			return err
+		}
+
+	default:
+		// Anything else, such as GNU sparse or volume entries, carries no file
+		return nil
+	}
+
+	if err := x.setOwner(parent, base, header); err != nil {
+		return err
+	}
+	// Changing the owner drops setuid bits, so the mode comes after it.
+	// chmod would follow a symlink, whose own mode means nothing anyway
+	if header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeDir {
+		if err := syscall.Fchmodat(dirfd, base, mode, 0); err != nil {
+			return err
+		}
+	}
+	// security.capability is dropped on chown as well
+	if err := x.setXattrs(parent, base, header); err != nil {
+		return err
+	}
+	if header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return utimensat(parent, base, header.AccessTime, header.ModTime)
+}
+
+// whiteout records a whiteout entry, applied once the whole layer is
+// extracted
+func (x *extractor) whiteout(name string) error {
+	dir, base := path.Split(name)
+	dir = path.Clean(dir)
+	switch {
+	case base == opaqueWhiteout:
+		x.opaques = append(x.opaques, dir)
+	case strings.HasPrefix(base, whiteoutMetaPrefix) || base == whiteoutPrefix:
+	default:
+		x.whiteouts = append(x.whiteouts, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+	}
+	return nil
+}
+
+// applyWhiteout hides what lower layers have at name. Flattening, it is
+// removed. Otherwise it becomes the 0/0 character device overlayfs takes
+// for a removed path, or an opaque directory if this layer put one there
+func (x *extractor) applyWhiteout(name string) error {
+	if x.flatten {
+		return x.removeLower(name)
+	}
+	if x.extracted[name] {
+		if mode, err := x.lstat(name); err == nil && mode&syscall.S_IFMT == syscall.S_IFDIR {
+			return x.setOpaque(name)
+		}
+		return nil
+	}
+
+	parent, base, err := x.root.parent(name, true)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	if err := remove(parent, base); err != nil {
+		return err
+	}
+	// Unprivileged users may create whiteout devices since Linux 5.8
+	return syscall.Mknodat(int(parent.Fd()), base, syscall.S_IFCHR, 0)
+}
+
+// applyOpaque hides what lower layers have below dir, but not what this
+// layer has there
+func (x *extractor) applyOpaque(dir string) error {
+	if err := x.root.mkdirAll(dir); err != nil {
+		return err
+	}
+	if x.flatten {
+		return x.clearLower(dir)
+	}
+	return x.setOpaque(dir)
+}
+
+// setOpaque marks a directory as one overlayfs doesn't merge with the
+// directories of lower layers
+func (x *extractor) setOpaque(dir string) error {
+	parent, base, err := x.root.parent(dir, false)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	return lsetxattr(parent, base, opaqueXattr, []byte("y"))
+}
+
+// opaqueXattr marks a directory opaque. Containers mount the overlay in
+// their user namespace, even as root, where overlayfs ignores trusted.*
+// xattrs and only looks at user.* ones with userxattr
+const opaqueXattr = "user.overlay.opaque"
+
+// removeLower deletes what lower layers left at name and keeps whatever
+// this layer put there, which for directories means going through them
+func (x *extractor) removeLower(name string) error {
+	if !x.extracted[name] {
+		parent, base, err := x.root.parent(name, false)
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer parent.Close()
+		return remove(parent, base)
+	}
+	if mode, err := x.lstat(name); err != nil || mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return nil
+	}
+	return x.clearLower(name)
+}
+
+// clearLower removes what lower layers left below the directory dir
+func (x *extractor) clearLower(dir string) error {
+	f, err := x.root.open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := x.removeLower(path.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lstat returns the mode of name below the root without following it if
+// it is a symlink
+func (x *extractor) lstat(name string) (uint32, error) {
+	parent, base, err := x.root.parent(name, false)
+	if err != nil {
+		return 0, err
+	}
+	defer parent.Close()
+	return lstat(parent, base)
+}
+
+// setOwner gives an entry the owner from its header. Unprivileged, every
+// file belongs to the user extracting it, which is root in the container,
+// so any other owner is recorded in overrideStatXattr instead
+func (x *extractor) setOwner(parent *os.File, base string, header *tar.Header) error {
+	if !x.rootless {
+		return syscall.Fchownat(int(parent.Fd()), base, header.Uid, header.Gid, atSymlinkNofollow)
+	}
+
+	isDevice := header.Typeflag == tar.TypeChar || header.Typeflag == tar.TypeBlock
+	if header.Uid == 0 && header.Gid == 0 && !isDevice {
+		return nil
+	}
+	if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeFifo {
+		// user.* xattrs only exist on regular files and directories
+		return nil
+	}
+	value := fmt.Sprintf("%d:%d:0%o", header.Uid, header.Gid, header.Mode&07777)
+	switch header.Typeflag {
+	case tar.TypeChar:
+		value += fmt.Sprintf(":char-%d-%d", header.Devmajor, header.Devminor)
+	case tar.TypeBlock:
+		value += fmt.Sprintf(":block-%d-%d", header.Devmajor, header.Devminor)
+	}
+	return lsetxattr(parent, base, overrideStatXattr, []byte(value))
+}
+
+// setXattrs restores the extended attributes recorded in PAX headers, such
+// as the security.capability that lets ping open raw sockets
+func (x *extractor) setXattrs(parent *os.File, base string, header *tar.Header) error {
+	for key, value := range header.PAXRecords {
+		name, ok := strings.CutPrefix(key, "SCHILY.xattr.")
+		if !ok || x.reservedXattr(name) {
+			continue
+		}
+		err := lsetxattr(parent, base, name, []byte(value))
+		// Some filesystems have no xattrs, and unprivileged users can't set
+		// security.* and trusted.* ones
+		if err == syscall.EOPNOTSUPP || (x.rootless && err == syscall.EPERM) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set xattr %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// reservedXattr reports whether a layer may not set an xattr itself. The
+// overlayfs ones decide what the container sees through the mount and the
+// ownership one stands in for chown, only the extractor sets them
+func (x *extractor) reservedXattr(name string) bool {
+	if strings.HasPrefix(name, "trusted.overlay.") || strings.HasPrefix(name, "user.overlay.") || name == overrideStatXattr {
+		return true
+	}
+	return x.rootless && strings.HasPrefix(name, "trusted.")
+}
+
+// finishDir restores the mode and times of a directory after its content
+// is extracted. Directories removed by a later entry are skipped
+func (x *extractor) finishDir(header *tar.Header) error {
+	name, _ := cleanName(header.Name)
+	parent, base, err := x.root.parent(name, false)
+	if errors.Is(err, syscall.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	if mode, err := lstat(parent, base); err != nil || mode&syscall.S_IFMT != syscall.S_IFDIR {
+		return nil
+	}
+	if err := syscall.Fchmodat(int(parent.Fd()), base, uint32(header.Mode)&07777, 0); err != nil {
+		return err
+	}
+	return utimensat(parent, base, header.AccessTime, header.ModTime)
+}
+
+// fileType returns the S_IF* bits for a device or fifo entry
+func fileType(typeflag byte) uint32 {
+	switch typeflag {
+	case tar.TypeChar:
+		return syscall.S_IFCHR
+	case tar.TypeBlock:
+		return syscall.S_IFBLK
+	default:
+		return syscall.S_IFIFO
+	}
+}
+
+// mkdev encodes a device number the way glibc's makedev(3) does
+func mkdev(major, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
@@ -0,0 +1,731 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// entry is a layer entry built in memory
+type entry struct {
+	header tar.Header
+	body   string
+}
+
+func reg(name, body string) entry {
+	return entry{tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(body))}, body}
+}
+
+func dir(name string) entry {
+	return entry{header: tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}}
+}
+
+func symlink(name, target string) entry {
+	return entry{header: tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0777}}
+}
+
+func hardlink(name, target string) entry {
+	return entry{header: tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: target, Mode: 0644}}
+}
+
+func layerTar(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := e.header
+		if err := w.WriteHeader(&header); err != nil {
+			t.Fatalf("failed to write %s: %v", header.Name, err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatalf("failed to write %s: %v", header.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractLayers extracts layers on top of each other into dest, stopping
+// at the first error
+func extractLayers(t *testing.T, dest string, flatten bool, layers ...[]entry) error {
+	t.Helper()
+	for _, layer := range layers {
+		if err := extractTar(bytes.NewReader(layerTar(t, layer)), dest, flatten); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileState is what a test expects to stay the same outside of the
+// extraction root
+type fileState struct {
+	Mode    fs.FileMode
+	Nlink   uint64
+	Content string
+	Xattrs  string
+}
+
+// snapshot records every file below dir except those below skip
+func snapshot(t *testing.T, dir, skip string) map[string]fileState {
+	t.Helper()
+	files := map[string]fileState{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == skip {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		state := fileState{Mode: info.Mode(), Nlink: info.Sys().(*syscall.Stat_t).Nlink}
+		switch {
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			state.Content = string(data)
+			state.Xattrs = xattrs(t, path)
+		case info.Mode().IsDir():
+			state.Xattrs = xattrs(t, path)
+		case info.Mode()&fs.ModeSymlink != 0:
+			if state.Content, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		files[path] = state
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// xattrs lists the extended attributes of path with their values
+func xattrs(t *testing.T, path string) string {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	n, err := syscall.Listxattr(path, buf)
+	if err == syscall.ENOTSUP {
+		return ""
+	}
+	if err != nil {
+		t.Fatalf("failed to list xattrs of %s: %v", path, err)
+	}
+	var list []string
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:n]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		value := make([]byte, 64*1024)
+		size, err := syscall.Getxattr(path, name, value)
+		if err != nil {
+			t.Fatalf("failed to get xattr %s of %s: %v", name, path, err)
+		}
+		list = append(list, name+"="+string(value[:size]))
+	}
+	return strings.Join(list, ",")
+}
+
+func TestExtractHostileLayers(t *testing.T) {
+	// Every layer runs against a fresh directory that has an outside
+	// directory next to the extraction root, which has to stay untouched
+	tests := []struct {
+		name   string
+		layers func(outside string) [][]entry
+		check  func(t *testing.T, dest string)
+	}{
+		{
+			name: "dotdot name",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{reg("../outside/evil", "pwned")}}
+			},
+		},
+		{
+			name: "dotdot below a directory",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{dir("a"), reg("a/../../outside/victim", "pwned")}}
+			},
+		},
+		{
+			name: "absolute name",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{reg(filepath.Join(outside, "victim"), "pwned")}}
+			},
+			check: func(t *testing.T, dest string) {
+				// Absolute names are relative to the root
+				outside := filepath.Join(filepath.Dir(dest), "outside")
+				if _, err := os.Lstat(filepath.Join(dest, outside, "victim")); err != nil {
+					t.Errorf("absolute entry not extracted below the root: %v", err)
+				}
+			},
+		},
+		{
+			name: "write through absolute symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("link", outside), reg("link/evil", "pwned"), reg("link/victim", "pwned")}}
+			},
+		},
+		{
+			name: "write through relative symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("link", "../outside"), reg("link/evil", "pwned"), reg("link/victim", "pwned")}}
+			},
+		},
+		{
+			name: "write through deep relative symlink",
+			layers: func(outside string) [][]entry {
+				up := strings.Repeat("../", 32)
+				return [][]entry{{dir("a"), dir("a/b"), symlink("a/b/link", up+outside), reg("a/b/link/victim", "pwned")}}
+			},
+		},
+		{
+			name: "write through symlink from a lower layer",
+			layers: func(outside string) [][]entry {
+				return [][]entry{
+					{symlink("link", outside)},
+					{reg("link/victim", "pwned"), dir("link/newdir")},
+				}
+			},
+		},
+		{
+			name: "write through symlink chain",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("a", "b"), symlink("b", outside), reg("a/victim", "pwned")}}
+			},
+		},
+		{
+			name: "symlink loop",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("a", "b"), symlink("b", "a"), reg("a/victim", "pwned")}}
+			},
+		},
+		{
+			name: "file replaces symlink to a file",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("victim", filepath.Join(outside, "victim")), reg("victim", "pwned")}}
+			},
+			check: func(t *testing.T, dest string) {
+				info, err := os.Lstat(filepath.Join(dest, "victim"))
+				if err != nil || !info.Mode().IsRegular() {
+					t.Errorf("symlink not replaced by a file: %v %v", info, err)
+				}
+			},
+		},
+		{
+			name: "file replaces symlink to a directory",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("dir", outside)}, {reg("dir", "pwned")}}
+			},
+			check: func(t *testing.T, dest string) {
+				info, err := os.Lstat(filepath.Join(dest, "dir"))
+				if err != nil || !info.Mode().IsRegular() {
+					t.Errorf("symlink not replaced by a file: %v %v", info, err)
+				}
+			},
+		},
+		{
+			name: "directory replaces symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("dir", outside)}, {dir("dir"), reg("dir/victim", "pwned")}}
+			},
+			check: func(t *testing.T, dest string) {
+				info, err := os.Lstat(filepath.Join(dest, "dir"))
+				if err != nil || !info.IsDir() {
+					t.Errorf("symlink not replaced by a directory: %v %v", info, err)
+				}
+			},
+		},
+		{
+			name: "file replaces directory",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{dir("dir"), reg("dir/file", "lower")}, {reg("dir", "file")}}
+			},
+			check: func(t *testing.T, dest string) {
+				data, err := os.ReadFile(filepath.Join(dest, "dir"))
+				if err != nil || string(data) != "file" {
+					t.Errorf("directory not replaced by a file: %q %v", data, err)
+				}
+			},
+		},
+		{
+			name: "hardlink to absolute path",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{hardlink("link", filepath.Join(outside, "victim"))}}
+			},
+		},
+		{
+			name: "hardlink to dotdot path",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{hardlink("link", "../outside/victim")}}
+			},
+		},
+		{
+			name: "hardlink through symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("dir", outside), hardlink("link", "dir/victim")}}
+			},
+		},
+		{
+			name: "hardlink to symlink then write",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("sym", filepath.Join(outside, "victim")), hardlink("link", "sym"), reg("link", "pwned")}}
+			},
+		},
+		{
+			name: "whiteout through symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("link", outside)}, {reg("link/.wh.victim", "")}}
+			},
+		},
+		{
+			name: "whiteout through relative symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("link", "../outside")}, {reg("link/.wh.victim", "")}}
+			},
+		},
+		{
+			name: "whiteout of dotdot",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{reg(".wh..", ""), reg("a/.wh...", ""), reg("../outside/.wh.victim", "")}}
+			},
+		},
+		{
+			name: "opaque through symlink",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("link", outside)}, {reg("link/.wh..wh..opq", "")}}
+			},
+		},
+		{
+			name: "opaque through symlink in the same layer",
+			layers: func(outside string) [][]entry {
+				return [][]entry{{symlink("link", outside), reg("link/.wh..wh..opq", "")}}
+			},
+		},
+	}
+
+	for _, flatten := range []bool{true, false} {
+		for _, test := range tests {
+			name := test.name
+			if flatten {
+				name += " flattened"
+			}
+			t.Run(name, func(t *testing.T) {
+				base := t.TempDir()
+				dest := filepath.Join(base, "root")
+				outside := filepath.Join(base, "outside")
+				for _, d := range []string{dest, outside} {
+					if err := os.Mkdir(d, 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.WriteFile(filepath.Join(outside, "victim"), []byte("original"), 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				before := snapshot(t, base, dest)
+				err := extractLayers(t, dest, flatten, test.layers(outside)...)
+				after := snapshot(t, base, dest)
+				if !reflect.DeepEqual(before, after) {
+					t.Fatalf("extraction changed files outside the root (err %v)\nbefore %v\nafter  %v", err, before, after)
+				}
+				if err == nil && test.check != nil {
+					test.check(t, dest)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenRootWithoutOpenat2(t *testing.T) {
+	saved := openat2
+	defer func() { openat2 = saved }()
+
+	for _, errno := range []syscall.Errno{syscall.ENOSYS, syscall.EPERM} {
+		openat2 = func(uintptr, *byte, *openHow) (uintptr, syscall.Errno) {
+			return 0, errno
+		}
+		dest := t.TempDir()
+		err := extractTar(bytes.NewReader(layerTar(t, []entry{reg("file", "content")})), dest, false)
+		if !errors.Is(err, errOpenat2Unsupported) {
+			t.Errorf("openat2 failing with %v: got %v, want %v", errno, err, errOpenat2Unsupported)
+		}
+		if _, err := os.Lstat(filepath.Join(dest, "file")); !os.IsNotExist(err) {
+			t.Errorf("openat2 failing with %v: entry extracted anyway", errno)
+		}
+	}
+}
+
+func TestOpenInRoot(t *testing.T) {
+	base := t.TempDir()
+	rootfs := filepath.Join(base, "rootfs")
+	outside := filepath.Join(base, "outside")
+	for _, dir := range []string{rootfs, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, target := range map[string]string{"abs": outside, "rel": "../outside", "up": "../../../..", "file": outside + "/file", "loop": "loop"} {
+		if err := os.Symlink(target, filepath.Join(rootfs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Absolute symlinks point below the root, where their targets are
+	// created when missing
+	tests := []struct {
+		name  string
+		isDir bool
+		want  string
+	}{
+		{"/abs/sub", true, outside + "/sub"},
+		{"/rel/deep/sub", true, "outside/deep/sub"},
+		{"/up/outside/sub", true, "outside/sub"},
+		{"/abs/deep/file", false, outside + "/deep/file"},
+		{"/file", false, outside + "/file"},
+		{"/../../outside/sub", true, ""},
+		{"/loop/sub", true, ""},
+	}
+	for _, test := range tests {
+		f, err := OpenInRoot(rootfs, test.name, test.isDir)
+		if test.want == "" {
+			if err == nil {
+				f.Close()
+				t.Errorf("OpenInRoot(%q) succeeded, want an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("OpenInRoot(%q) failed: %v", test.name, err)
+			continue
+		}
+		// The symlinks are followed as if rootfs was the filesystem root
+		got, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", f.Fd()))
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(rootfs, test.want); got != want {
+			t.Errorf("OpenInRoot(%q) opened %s, want %s", test.name, got, want)
+		}
+	}
+
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) > 0 {
+		t.Errorf("OpenInRoot created %v outside of the root: %v", entries, err)
+	}
+}
+
+// whiteoutLayers are a lower layer and an upper one that hides parts of
+// it, with whiteouts listed before and after what the upper layer adds
+func whiteoutLayers() (lower, upper []entry) {
+	lower = []entry{
+		dir("a"), reg("a/x", "x"), reg("a/y", "y"),
+		reg("b", "b"),
+		dir("c"), reg("c/z", "z"),
+		dir("d"), reg("d/old", "old"),
+		reg("f", "old"),
+		reg("g", "old"),
+	}
+	upper = []entry{
+		reg(".wh.b", ""),
+		reg("a/.wh.x", ""),
+		// Opaque before and after the new children of the directory
+		reg("c/.wh..wh..opq", ""), reg("c/new", "new"),
+		reg("d/new", "new"), reg("d/.wh..wh..opq", ""),
+		// Whiteouts only hide lower layers, not what comes later in theirs
+		reg(".wh.f", ""), reg("f", "new"),
+		reg(".wh.g", ""), dir("g"), reg("g/inner", "new"),
+		reg(".wh.nothing", ""),
+	}
+	return lower, upper
+}
+
+// tree lists every path below dir with the content of regular files and
+// the kind of everything else
+func tree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode().IsRegular():
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[name] = string(data)
+		case info.IsDir():
+			files[name] = "dir"
+			if opaque(t, path) {
+				files[name] = "opaque dir"
+			}
+		case info.Mode()&fs.ModeCharDevice != 0 && info.Sys().(*syscall.Stat_t).Rdev == 0:
+			files[name] = "whiteout"
+		default:
+			files[name] = info.Mode().String()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// opaque reports whether dir carries the overlayfs opaque xattr
+func opaque(t *testing.T, dir string) bool {
+	t.Helper()
+	value := make([]byte, 16)
+	n, err := syscall.Getxattr(dir, opaqueXattr, value)
+	if err == syscall.ENODATA {
+		return false
+	}
+	if err != nil {
+		t.Fatalf("failed to get %s of %s: %v", opaqueXattr, dir, err)
+	}
+	return string(value[:n]) == "y"
+}
+
+func TestWhiteoutsFlattened(t *testing.T) {
+	lower, upper := whiteoutLayers()
+	dest := t.TempDir()
+	if err := extractLayers(t, dest, true, lower, upper); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"a":       "dir",
+		"a/y":     "y",
+		"c":       "dir",
+		"c/new":   "new",
+		"d":       "dir",
+		"d/new":   "new",
+		"f":       "new",
+		"g":       "dir",
+		"g/inner": "new",
+	}
+	if got := tree(t, dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("flattened layers:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestWhiteoutsOverlay(t *testing.T) {
+	_, upper := whiteoutLayers()
+	dest := t.TempDir()
+	if err := extractLayers(t, dest, false, upper); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"a":       "dir",
+		"a/x":     "whiteout",
+		"b":       "whiteout",
+		"c":       "opaque dir",
+		"c/new":   "new",
+		"d":       "opaque dir",
+		"d/new":   "new",
+		"f":       "new",
+		"g":       "opaque dir",
+		"g/inner": "new",
+		"nothing": "whiteout",
+	}
+	if got := tree(t, dest); !reflect.DeepEqual(got, want) {
+		t.Errorf("overlay layer:\n got %v\nwant %v", got, want)
+	}
+}
+
+// TestWhiteoutsOpaqueOnly covers an opaque marker without an entry for its
+// directory, which still has to end up as an empty directory
+// overlayTestDir is set for the copy of the test binary that
+// TestWhiteoutsMounted runs in new user and mount namespaces
+const overlayTestDir = "GONTAINERS_OVERLAY_TEST_DIR"
+
+// TestWhiteoutsMounted stacks the layers with overlayfs the way containers
+// do, in a user namespace and with userxattr even as root, and expects the
+// same files as flattening them. Inspecting the xattrs alone can't tell
+// whether overlayfs honours them
+func TestWhiteoutsMounted(t *testing.T) {
+	lower, upper := whiteoutLayers()
+	dir := t.TempDir()
+	for name, layer := range map[string][]entry{"lower": lower, "upper": upper, "merged": nil} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractLayers(t, filepath.Join(dir, name), false, layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestOverlayMount$", "-test.v")
+	cmd.Env = append(os.Environ(), overlayTestDir+"="+dir)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Skipf("no user namespaces: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("mounting the layers failed: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "tree.json"))
+	if os.IsNotExist(err) {
+		t.Skipf("overlayfs can't be mounted:\n%s", output)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	flattened := t.TempDir()
+	if err := extractLayers(t, flattened, true, lower, upper); err != nil {
+		t.Fatal(err)
+	}
+	if want := tree(t, flattened); !reflect.DeepEqual(got, want) {
+		t.Errorf("mounted layers:\n got %v\nwant %v", got, want)
+	}
+}
+
+// TestOverlayMount is the side of TestWhiteoutsMounted in the namespaces
+func TestOverlayMount(t *testing.T) {
+	dir := os.Getenv(overlayTestDir)
+	if dir == "" {
+		t.Skip("only run by TestWhiteoutsMounted")
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		t.Fatalf("failed to make / private: %v", err)
+	}
+	merged := filepath.Join(dir, "merged")
+	options := "lowerdir=" + filepath.Join(dir, "upper") + ":" + filepath.Join(dir, "lower") + ",userxattr"
+	if err := syscall.Mount("overlay", merged, "overlay", syscall.MS_RDONLY, options); err != nil {
+		t.Skipf("failed to mount overlay: %v", err)
+	}
+
+	data, err := json.Marshal(tree(t, merged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tree.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWhiteoutsOpaqueOnly(t *testing.T) {
+	for _, flatten := range []bool{true, false} {
+		dest := t.TempDir()
+		lower := []entry{dir("e"), reg("e/old", "old")}
+		if !flatten {
+			lower = nil
+		}
+		if err := extractLayers(t, dest, flatten, lower, []entry{reg("e/.wh..wh..opq", "")}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]string{"e": "dir"}
+		if !flatten {
+			want["e"] = "opaque dir"
+		}
+		if got := tree(t, dest); !reflect.DeepEqual(got, want) {
+			t.Errorf("flatten %v:\n got %v\nwant %v", flatten, got, want)
+		}
+	}
+}
+
+func TestReservedXattrs(t *testing.T) {
+	withXattrs := func(e entry, xattrs map[string]string) entry {
+		e.header.Format = tar.FormatPAX
+		e.header.PAXRecords = map[string]string{}
+		for name, value := range xattrs {
+			e.header.PAXRecords["SCHILY.xattr."+name] = value
+		}
+		return e
+	}
+	reserved := map[string]string{
+		"trusted.overlay.opaque":        "y",
+		"trusted.overlay.redirect":      "/elsewhere",
+		"user.overlay.opaque":           "y",
+		"user.overlay.metacopy":         "",
+		"user.containers.override_stat": "0:0:04755",
+	}
+	kept := map[string]string{"user.kept": "yes"}
+	all := map[string]string{}
+	for name, value := range reserved {
+		all[name] = value
+	}
+	for name, value := range kept {
+		all[name] = value
+	}
+
+	dest := t.TempDir()
+	layer := []entry{withXattrs(dir("dir"), all), withXattrs(reg("file", "content"), all)}
+	if err := extractLayers(t, dest, false, layer); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"dir", "file"} {
+		got := xattrs(t, filepath.Join(dest, name))
+		for xattr := range reserved {
+			if strings.Contains(got, xattr+"=") {
+				t.Errorf("%s got the reserved xattr %s from the layer: %s", name, xattr, got)
+			}
+		}
+		// Filesystems without user xattrs keep none at all
+		if got != "" && !strings.Contains(got, "user.kept=yes") {
+			t.Errorf("%s lost user.kept: %s", name, got)
+		}
+	}
+}
+
+func TestReservedXattrNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		root     bool
+		rootless bool
+	}{
+		{"trusted.overlay.opaque", true, true},
+		{"trusted.overlay.origin", true, true},
+		{"user.overlay.redirect", true, true},
+		{"user.containers.override_stat", true, true},
+		{"trusted.other", false, true},
+		{"security.capability", false, false},
+		{"user.mime_type", false, false},
+	}
+	for _, test := range tests {
+		if got := (&extractor{}).reservedXattr(test.name); got != test.root {
+			t.Errorf("reservedXattr(%q) as root = %v, want %v", test.name, got, test.root)
+		}
+		if got := (&extractor{rootless: true}).reservedXattr(test.name); got != test.rootless {
+			t.Errorf("reservedXattr(%q) rootless = %v, want %v", test.name, got, test.rootless)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/beltranaceves/gontainers/registry"
+)
+
+// DefaultConcurrency is how many layers Pull downloads at once by default
+const DefaultConcurrency = 3
+
+// maxAttempts is how often a blob download is tried, every attempt resuming
+// where the previous one stopped
+const maxAttempts = 3
+
+// progressInterval limits how often download progress is reported
+const progressInterval = 100 * time.Millisecond
+
+var errAborted = errors.New("pull aborted")
+
+// ProgressEvent reports how far a pull got. Events about a layer carry its
+// short digest as ID, Current and Total count the bytes downloaded
+type ProgressEvent struct {
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+}
+
+// PullOptions configures Pull
+type PullOptions struct {
+	Platform    registry.Platform
+	Concurrency int                 // layers downloaded at once, DefaultConcurrency when 0
+	Progress    func(ProgressEvent) // called from several goroutines, may be nil
+}
+
+// Pull downloads the image ref points to into the store and extracts its
+// layers. Blobs already in the store, such as layers shared with other
+// images, are not downloaded again. Layers are downloaded concurrently and
+// extracted bottom up as soon as each one and those below it are complete
+func (s *Store) Pull(client *registry.Client, ref string, options PullOptions) (*Image, error) {
+	progress := options.Progress
+	if progress == nil {
+		progress = func(ProgressEvent) {}
+	}
+	concurrency := options.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+
+	parsed, err := registry.ParseImageReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	progress(ProgressEvent{Status: fmt.Sprintf("Pulling %s for %s", parsed, options.Platform)})
+
+	manifest, err := client.ResolveManifest(parsed, options.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %v", err)
+	}
+	// Digests end up in paths, so they are checked before anything is written
+	for _, desc := range append([]registry.Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := checkDigest(desc.Digest); err != nil {
+			return nil, err
+		}
+	}
+	// Layers that can't be extracted are not worth downloading
+	for _, layer := range manifest.Layers {
+		if _, err := decompressor(layer.MediaType); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", layer.Digest, err)
+		}
+	}
+
+	// Every layer reports on its own channel once downloaded. Returning
+	// early stops the downloads still running
+	abort := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(abort)
+		wg.Wait()
+	}()
+
+	slots := make(chan struct{}, concurrency)
+	done := make([]chan error, len(manifest.Layers))
+	downloaded := make([]bool, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		id := shortDigest(layer.Digest)
+		done[i] = make(chan error, 1)
+		if s.HasBlob(layer) {
+			progress(ProgressEvent{ID: id, Status: "Already exists"})
+			done[i] <- nil
+			continue
+		}
+
+		downloaded[i] = true
+		progress(ProgressEvent{ID: id, Status: "Waiting"})
+		wg.Add(1)
+		go func(layer registry.Descriptor, done chan<- error) {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-abort:
+				done <- errAborted
+				return
+			}
+			defer func() { <-slots }()
+
+			err := s.fetch(client, parsed, layer, abort, func(current int64) {
+				progress(ProgressEvent{ID: id, Status: "Downloading", Current: current, Total: layer.Size})
+			})
+			if err == nil {
+				progress(ProgressEvent{ID: id, Status: "Download complete"})
+			}
+			done <- err
+		}(layer, done[i])
+	}
+
+	if !s.HasBlob(manifest.Config) {
+		if err := s.fetch(client, parsed, manifest.Config, abort, func(int64) {}); err != nil {
+			return nil, fmt.Errorf("failed to download config: %v", err)
+		}
+	}
+
+	for i, layer := range manifest.Layers {
+		id := shortDigest(layer.Digest)
+		if err := <-done[i]; err != nil {
+			return nil, fmt.Errorf("failed to download layer %s: %v", layer.Digest, err)
+		}
+		if _, err := os.Stat(s.LayerDir(layer.Digest)); err != nil {
+			progress(ProgressEvent{ID: id, Status: "Extracting"})
+			if err := s.extract(layer); err != nil {
+				return nil, fmt.Errorf("failed to extract layer %s: %v", layer.Digest, err)
+			}
+		}
+		if downloaded[i] {
+			progress(ProgressEvent{ID: id, Status: "Pull complete"})
+		}
+	}
+
+	// The manifest is stored last, so a reference never points to an image
+	// with missing blobs
+	desc := registry.Descriptor{MediaType: manifest.MediaType, Digest: manifest.Digest, Size: int64(len(manifest.Raw))}
+	if err := s.WriteBlob(desc, bytes.NewReader(manifest.Raw)); err != nil {
+		return nil, fmt.Errorf("failed to store manifest: %v", err)
+	}
+	if err := s.Tag(ref, manifest.Digest); err != nil {
+		return nil, err
+	}
+
+	progress(ProgressEvent{Status: "Digest: " + manifest.Digest})
+	return &Image{Name: parsed.String(), Manifest: manifest}, nil
+}
+
+// shortDigest identifies a layer in progress events the way docker does
+func shortDigest(digest string) string {
+	_, encoded, _ := strings.Cut(digest, ":")
+	if len(encoded) > 12 {
+		encoded = encoded[:12]
+	}
+	return encoded
+}
+
+// fetch downloads a blob into its partial file below ingest, continuing
+// whatever an earlier attempt or pull left there, and moves it into the
+// store once verified
+func (s *Store) fetch(client *registry.Client, ref registry.ImageReference, desc registry.Descriptor, abort <-chan struct{}, report func(current int64)) error {
+	path := s.ingestPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create ingest directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open partial blob: %v", err)
+	}
+	defer file.Close()
+
+	// Another pull may be downloading the same blob, it is done once the lock is free
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock partial blob: %v", err)
+	}
+	if s.HasBlob(desc) {
+		return nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = s.download(client, ref, desc, file, abort, report)
+		if err == nil {
+			break
+		}
+		if err == errAborted || attempt == maxAttempts {
+			return err
+		}
+	}
+	return s.commit(desc, path)
+}
+
+// download appends the rest of a blob to its partial file
+func (s *Store) download(client *registry.Client, ref registry.ImageReference, desc registry.Descriptor, file *os.File, abort <-chan struct{}, report func(current int64)) error {
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > desc.Size {
+		offset = 0
+	}
+	if offset == desc.Size && offset > 0 {
+		return nil
+	}
+
+	blob, start, err := client.FetchBlob(ref, desc.Digest, offset)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	// The registry may send the whole blob instead of the requested range
+	if err := file.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	report(start)
+	_, err = io.Copy(file, &progressReader{r: blob, current: start, report: report, abort: abort})
+	return err
+}
+
+// progressReader reports how much of a blob has been read, and fails reads
+// once abort is closed
+type progressReader struct {
+	r        io.Reader
+	current  int64
+	reported time.Time
+	report   func(current int64)
+	abort    <-chan struct{}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	select {
+	case <-p.abort:
+		return 0, errAborted
+	default:
+	}
+
+	n, err := p.r.Read(b)
+	p.current += int64(n)
+	if err != nil || time.Since(p.reported) >= progressInterval {
+		p.reported = time.Now()
+		p.report(p.current)
+	}
+	return n, err
+}
+
+// extract unpacks a stored layer into a temporary directory that is renamed
+// into place once complete, so a layer directory is never half extracted
+func (s *Store) extract(layer registry.Descriptor) error {
+	dir := s.LayerDir(layer.Digest)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create layers directory: %v", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create layer directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	// MkdirTemp creates it private, but it becomes a container's root
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+
+	if err := extractLayer(s.BlobPath(layer.Digest), layer.MediaType, tmp, false); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dir)
+}
+
+// Flatten extracts every layer of img on top of each other into dir, with
+// whiteouts applied, for when the layers can't be stacked with overlayfs
+func (s *Store) Flatten(img *Image, dir string) error {
+	for _, layer := range img.Manifest.Layers {
+		if err := extractLayer(s.BlobPath(layer.Digest), layer.MediaType, dir, true); err != nil {
+			return fmt.Errorf("failed to extract layer %s: %v", layer.Digest, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// openat2(2) and O_PATH are not in package syscall. The syscall number is
+// the same on every architecture, and it exists on every kernel with
+// CLONE_INTO_CGROUP
+const (
+	sysOpenat2          = 437
+	resolveNoMagiclinks = 0x02
+	resolveInRoot       = 0x10
+	oPath               = 0x200000
+	atSymlinkNofollow   = 0x100
+)
+
+type openHow struct {
+	Flags   uint64
+	Mode    uint64
+	Resolve uint64
+}
+
+// root is a directory a layer is extracted into. Paths below it are
+// resolved by the kernel as if it was the filesystem root, so neither ".."
+// nor symlinks created by earlier entries lead outside of it
+type root struct {
+	dir *os.File
+}
+
+// errOpenat2Unsupported means the kernel can't confine path resolution to
+// a root, so no layer can be extracted safely
+var errOpenat2Unsupported = errors.New("openat2 unsupported, layers need Linux 5.6 or newer and a seccomp profile that allows openat2")
+
+func openRoot(dir string) (*root, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &root{dir: f}
+
+	// Old kernels have no openat2, and seccomp profiles that don't know it
+	// deny it with ENOSYS or EPERM
+	probe, err := r.open(".", oPath, 0)
+	if errors.Is(err, syscall.ENOSYS) || errors.Is(err, syscall.EPERM) {
+		f.Close()
+		return nil, fmt.Errorf("%w: %v", errOpenat2Unsupported, err)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	probe.Close()
+	return r, nil
+}
+
+func (r *root) Close() error {
+	return r.dir.Close()
+}
+
+// cleanName turns the name of a layer entry into a path relative to the
+// root. Names that climb out of it with ".." are rejected rather than
+// clamped, a well formed layer never has them
+func cleanName(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimLeft(name, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%q points outside of the layer", name)
+	}
+	return cleaned, nil
+}
+
+// open opens name below the root with RESOLVE_IN_ROOT
+func (r *root) open(name string, flags int, mode uint32) (*os.File, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	how := openHow{
+		Flags:   uint64(flags | syscall.O_CLOEXEC),
+		Mode:    uint64(mode),
+		Resolve: resolveInRoot | resolveNoMagiclinks,
+	}
+	for {
+		fd, errno := openat2(r.dir.Fd(), p, &how)
+		// The kernel asks to retry when a concurrent rename could have
+		// made the resolution unsafe
+		if errno == syscall.EAGAIN || errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return nil, &os.PathError{Op: "openat2", Path: name, Err: errno}
+		}
+		return os.NewFile(fd, name), nil
+	}
+}
+
+// openat2 is a variable so tests can stand in for kernels without it
+var openat2 = func(dirfd uintptr, name *byte, how *openHow) (uintptr, syscall.Errno) {
+	fd, _, errno := syscall.Syscall6(sysOpenat2, dirfd, uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(how)), unsafe.Sizeof(*how), 0, 0)
+	return fd, errno
+}
+
+// parent opens the directory holding name and returns it along with the
+// last component of name, which the caller operates on relative to it.
+// With create, missing directories on the way are created
+func (r *root) parent(name string, create bool) (*os.File, string, error) {
+	dir, base := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := r.open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err == nil || !create || !errors.Is(err, syscall.ENOENT) {
+		return f, base, err
+	}
+
+	if err := r.mkdirAll(path.Clean(dir)); err != nil {
+		return nil, "", err
+	}
+	f, err = r.open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	return f, base, err
+}
+
+// mkdirAll creates dir below the root along with any missing parents
+func (r *root) mkdirAll(dir string) error {
+	if dir == "." {
+		return nil
+	}
+	parent, base, err := r.parent(dir, true)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	if err := syscall.Mkdirat(int(parent.Fd()), base, 0755); err != nil && err != syscall.EEXIST {
+		return &os.PathError{Op: "mkdir", Path: dir, Err: err}
+	}
+	return nil
+}
+
+// OpenInRoot opens name below dir as an O_PATH descriptor, resolved the way
+// layers are extracted so neither ".." nor symlinks lead outside of dir.
+// When nothing is there it creates a directory, or an empty file without
+// isDir. Containers mount on the descriptor through /proc/self/fd so that
+// the symlinks of an image can't point a mount at the host
+func OpenInRoot(dir, name string, isDir bool) (*os.File, error) {
+	r, err := openRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	name, err = cleanName(name)
+	if err != nil {
+		return nil, err
+	}
+	// Symlinks to paths that don't exist yet are common in images, like
+	// /var/run to /run, and what they point to is created instead
+	if name, err = r.resolve(name); err != nil {
+		return nil, err
+	}
+	if isDir {
+		err = r.mkdirAll(name)
+	} else {
+		err = r.createFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.open(name, oPath, 0)
+}
+
+// maxSymlinks is how many symlinks resolve follows, like the kernel
+const maxSymlinks = 40
+
+// resolve replaces the symlinks in name with what they point to, as the
+// kernel would with the root as the filesystem root, and returns a name
+// without symlinks. Unlike the kernel it carries on past missing entries
+func (r *root) resolve(name string) (string, error) {
+	var resolved []string
+	rest := strings.Split(name, "/")
+	for links := 0; len(rest) > 0; {
+		component := rest[0]
+		rest = rest[1:]
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			// The root is its own parent
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			continue
+		}
+
+		current := path.Join(resolved...)
+		parent, err := r.open(path.Join(".", current), syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+		if errors.Is(err, syscall.ENOENT) {
+			resolved = append(append(resolved, component), rest...)
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		mode, err := lstat(parent, component)
+		if err != nil || mode&syscall.S_IFMT != syscall.S_IFLNK {
+			parent.Close()
+			resolved = append(resolved, component)
+			continue
+		}
+		target, err := os.Readlink(procPath(parent, component))
+		parent.Close()
+		if err != nil {
+			return "", err
+		}
+
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+		}
+		if path.IsAbs(target) {
+			resolved = nil
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return path.Join(append([]string{"."}, resolved...)...), nil
+}
+
+// createFile creates an empty file at name below the root unless
+// something is there already
+func (r *root) createFile(name string) error {
+	parent, base, err := r.parent(name, true)
+	if err != nil {
+		return err
+	}
+	defer parent.Close()
+	fd, err := syscall.Openat(int(parent.Fd()), base, syscall.O_CREAT|syscall.O_EXCL|syscall.O_WRONLY|syscall.O_CLOEXEC, 0644)
+	if err == syscall.EEXIST {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	return syscall.Close(fd)
+}
+
+// lstat returns the mode of base in parent without following a symlink
+func lstat(parent *os.File, base string) (uint32, error) {
+	fd, err := syscall.Openat(int(parent.Fd()), base, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Close(fd)
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Mode, nil
+}
+
+// remove deletes base from parent, directories with everything in them
+func remove(parent *os.File, base string) error {
+	err := syscall.Unlinkat(int(parent.Fd()), base)
+	if err == nil || err == syscall.ENOENT {
+		return nil
+	}
+	if err != syscall.EISDIR {
+		return &os.PathError{Op: "unlink", Path: base, Err: err}
+	}
+	// RemoveAll does not follow symlinks below the directory it is given
+	return os.RemoveAll(procPath(parent, base))
+}
+
+func symlinkat(target string, parent *os.File, base string) error {
+	t, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	b, err := syscall.BytePtrFromString(base)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(t)), parent.Fd(), uintptr(unsafe.Pointer(b)))
+	if errno != 0 {
+		return &os.LinkError{Op: "symlink", Old: target, New: base, Err: errno}
+	}
+	return nil
+}
+
+func linkat(oldParent *os.File, oldBase string, newParent *os.File, newBase string) error {
+	o, err := syscall.BytePtrFromString(oldBase)
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(newBase)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LINKAT, oldParent.Fd(), uintptr(unsafe.Pointer(o)), newParent.Fd(), uintptr(unsafe.Pointer(n)), 0, 0)
+	if errno != 0 {
+		return &os.LinkError{Op: "link", Old: oldBase, New: newBase, Err: errno}
+	}
+	return nil
+}
+
+// procPath names base in parent through /proc, for syscalls that take no
+// directory descriptor. Only the last component is looked up by name
+func procPath(parent *os.File, base string) string {
+	return fmt.Sprintf("/proc/self/fd/%d/%s", parent.Fd(), base)
+}
+
+// lsetxattr sets an extended attribute on base in parent, on a symlink
+// itself rather than its target
+func lsetxattr(parent *os.File, base, name string, value []byte) error {
+	p, err := syscall.BytePtrFromString(procPath(parent, base))
+	if err != nil {
+		return err
+	}
+	n, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	var v unsafe.Pointer
+	if len(value) > 0 {
+		v = unsafe.Pointer(&value[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LSETXATTR, uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(n)), uintptr(v), uintptr(len(value)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// utimensat sets the access and modification times of base in parent
+// without following a symlink. A zero access time becomes the mtime
+func utimensat(parent *os.File, base string, atime, mtime time.Time) error {
+	if atime.IsZero() {
+		atime = mtime
+	}
+	b, err := syscall.BytePtrFromString(base)
+	if err != nil {
+		return err
+	}
+	times := [2]syscall.Timespec{syscall.NsecToTimespec(atime.UnixNano()), syscall.NsecToTimespec(mtime.UnixNano())}
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, parent.Fd(), uintptr(unsafe.Pointer(b)), uintptr(unsafe.Pointer(&times[0])), atSymlinkNofollow, 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "utimensat", Path: base, Err: errno}
+	}
+	return nil
+}
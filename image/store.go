@@ -0,0 +1,413 @@
+// Package image keeps pulled images in a content addressable store. Blobs
+// are stored once by digest and shared between images, references such as
+// alpine:latest point to the digest of a manifest
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/beltranaceves/gontainers/container/state"
+	"github.com/beltranaceves/gontainers/registry"
+)
+
+const (
+	refsFile = "refs.json"
+	lockFile = "refs.lock"
+)
+
+// Store keeps images below Root:
+//
+//	blobs/sha256/<hex>   manifests, configs and compressed layers
+//	layers/sha256/<hex>  layers extracted from the blob with that digest
+//	ingest/sha256/<hex>  blobs still being downloaded
+//	refs.json            references and the manifest digests they point to
+type Store struct {
+	Root string
+}
+
+func NewStore(root string) *Store {
+	return &Store{Root: root}
+}
+
+// Default returns the store in the images directory of the state root
+func Default() *Store {
+	return NewStore(filepath.Join(state.DefaultRoot(), "images"))
+}
+
+// Name returns the form a reference is stored under, so that alpine and
+// docker.io/library/alpine:latest are the same image
+func Name(ref string) (string, error) {
+	parsed, err := registry.ParseImageReference(ref)
+	if err != nil {
+		return "", err
+	}
+	return parsed.String(), nil
+}
+
+// checkDigest rejects digests the store can't verify, and anything that
+// would not be a plain file name once joined to a path
+func checkDigest(digest string) error {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest %q", digest)
+	}
+	if len(encoded) != sha256.Size*2 || strings.Trim(encoded, "0123456789abcdef") != "" {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
+	return nil
+}
+
+// BlobPath returns where the blob with digest is stored
+func (s *Store) BlobPath(digest string) string {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(s.Root, "blobs", algorithm, encoded)
+}
+
+// LayerDir returns where the layer in the blob with digest is extracted to
+func (s *Store) LayerDir(digest string) string {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(s.Root, "layers", algorithm, encoded)
+}
+
+// HasBlob reports whether the blob desc points to is stored. Blobs are
+// verified before they are stored, so a matching size is enough
+func (s *Store) HasBlob(desc registry.Descriptor) bool {
+	info, err := os.Stat(s.BlobPath(desc.Digest))
+	return err == nil && info.Size() == desc.Size
+}
+
+// ingestPath returns the partial file the blob with digest is downloaded to
+func (s *Store) ingestPath(digest string) string {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(s.Root, "ingest", algorithm, encoded)
+}
+
+// WriteBlob stores the content of r as the blob desc points to, once it
+// has been checked to have the size and digest desc gives
+func (s *Store) WriteBlob(desc registry.Descriptor, r io.Reader) error {
+	if err := checkDigest(desc.Digest); err != nil {
+		return err
+	}
+	dir := filepath.Join(s.Root, "ingest")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create ingest directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write blob: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob %s: %v", desc.Digest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob %s: %v", desc.Digest, err)
+	}
+	return s.commit(desc, tmp.Name())
+}
+
+// commit checks that the file at path has the size and digest desc gives
+// and renames it into the store. A file that doesn't match is removed, so
+// a corrupt download starts over instead of being resumed
+func (s *Store) commit(desc registry.Descriptor, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify blob %s: %v", desc.Digest, err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to verify blob %s: %v", desc.Digest, err)
+	}
+
+	if size != desc.Size {
+		os.Remove(path)
+		return fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", desc.Digest, desc.Size, size)
+	}
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != desc.Digest {
+		os.Remove(path)
+		return fmt.Errorf("digest mismatch: expected %s, got %s", desc.Digest, digest)
+	}
+
+	blobPath := s.BlobPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %v", err)
+	}
+	if err := os.Rename(path, blobPath); err != nil {
+		return fmt.Errorf("failed to write blob %s: %v", desc.Digest, err)
+	}
+	return nil
+}
+
+// ReadBlob returns the content of a stored blob
+func (s *Store) ReadBlob(digest string) ([]byte, error) {
+	if err := checkDigest(digest); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.BlobPath(digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("blob %s not found", digest)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// Image is a reference resolved to the manifest it points to
+type Image struct {
+	Name     string
+	Manifest *registry.Manifest
+}
+
+// Resolve looks up the image a reference points to
+func (s *Store) Resolve(ref string) (*Image, error) {
+	refs, err := s.refs()
+	if err != nil {
+		return nil, err
+	}
+	name, err := Name(ref)
+	if err != nil {
+		return nil, err
+	}
+	digest, ok := refs[name]
+	if !ok {
+		return nil, fmt.Errorf("image %s not found, pull it first", ref)
+	}
+
+	data, err := s.ReadBlob(digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest of %s: %v", ref, err)
+	}
+	var manifest registry.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %v", ref, err)
+	}
+	manifest.Digest = digest
+	manifest.Raw = data
+	return &Image{Name: name, Manifest: &manifest}, nil
+}
+
+// Layers returns the extracted layers of img, from the bottom one up
+func (s *Store) Layers(img *Image) ([]string, error) {
+	var layers []string
+	for _, layer := range img.Manifest.Layers {
+		dir := s.LayerDir(layer.Digest)
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("layer %s of %s is missing, pull it again", layer.Digest, img.Name)
+		}
+		layers = append(layers, dir)
+	}
+	return layers, nil
+}
+
+// Config returns the config blob of img
+func (s *Store) Config(img *Image) ([]byte, error) {
+	return s.ReadBlob(img.Manifest.Config.Digest)
+}
+
+// Created returns when img was built according to its config
+func (s *Store) Created(img *Image) (time.Time, error) {
+	data, err := s.Config(img)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var config struct {
+		Created time.Time `json:"created"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse config of %s: %v", img.Name, err)
+	}
+	return config.Created, nil
+}
+
+// Size returns the size of the blobs img consists of
+func (img *Image) Size() int64 {
+	size := img.Manifest.Config.Size
+	for _, layer := range img.Manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// List returns every image in the store, sorted by reference
+func (s *Store) List() ([]*Image, error) {
+	refs, err := s.refs()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var images []*Image
+	for _, name := range names {
+		img, err := s.Resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// Remove deletes the reference ref and returns the digests of the blobs
+// that no image uses anymore, which are deleted along with their extracted
+// layers. A manifest another reference points to or one of inUse, the
+// manifests containers were created from, is kept, and so are the layers
+// other manifests share
+func (s *Store) Remove(ref string, inUse []string) ([]string, error) {
+	img, err := s.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	err = s.updateRefs(func(refs map[string]string) error {
+		delete(refs, img.Name)
+
+		// Every blob still in use, counted through the remaining manifests
+		// and those of containers as far as they are still stored
+		manifests := map[string]bool{}
+		for _, digest := range refs {
+			manifests[digest] = true
+		}
+		for _, digest := range inUse {
+			if checkDigest(digest) != nil {
+				continue
+			}
+			if _, err := os.Stat(s.BlobPath(digest)); err == nil {
+				manifests[digest] = true
+			}
+		}
+		used := map[string]bool{}
+		for digest := range manifests {
+			used[digest] = true
+			data, err := s.ReadBlob(digest)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest %s: %v", digest, err)
+			}
+			var manifest registry.Manifest
+			if err := json.Unmarshal(data, &manifest); err != nil {
+				return fmt.Errorf("failed to parse manifest %s: %v", digest, err)
+			}
+			used[manifest.Config.Digest] = true
+			for _, layer := range manifest.Layers {
+				used[layer.Digest] = true
+			}
+		}
+		if used[img.Manifest.Digest] {
+			return nil
+		}
+
+		for _, desc := range append([]registry.Descriptor{{Digest: img.Manifest.Digest}, img.Manifest.Config}, img.Manifest.Layers...) {
+			if used[desc.Digest] {
+				continue
+			}
+			used[desc.Digest] = true
+			if err := os.RemoveAll(s.LayerDir(desc.Digest)); err != nil {
+				return fmt.Errorf("failed to remove layer %s: %v", desc.Digest, err)
+			}
+			if err := os.Remove(s.BlobPath(desc.Digest)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove blob %s: %v", desc.Digest, err)
+			}
+			deleted = append(deleted, desc.Digest)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (s *Store) refs() (map[string]string, error) {
+	refs := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(s.Root, refsFile))
+	if os.IsNotExist(err) {
+		return refs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image references: %v", err)
+	}
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("failed to parse image references: %v", err)
+	}
+	return refs, nil
+}
+
+// Tag points ref at the manifest with digest, which has to be stored
+func (s *Store) Tag(ref, digest string) error {
+	name, err := Name(ref)
+	if err != nil {
+		return err
+	}
+	return s.updateRefs(func(refs map[string]string) error {
+		refs[name] = digest
+		return nil
+	})
+}
+
+// updateRefs modifies the references while holding the store's lock, and
+// writes them to a temporary file renamed over the previous one
+func (s *Store) updateRefs(update func(refs map[string]string) error) error {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return fmt.Errorf("failed to create image store: %v", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(s.Root, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to lock image references: %v", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock image references: %v", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	refs, err := s.refs()
+	if err != nil {
+		return err
+	}
+	if err := update(refs); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(refs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Root, refsFile+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write image references: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write image references: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write image references: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.Root, refsFile)); err != nil {
+		return fmt.Errorf("failed to write image references: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/beltranaceves/gontainers/registry"
+)
+
+// writeBlob stores data and returns its descriptor
+func writeBlob(t *testing.T, s *Store, data []byte) registry.Descriptor {
+	t.Helper()
+	sum := sha256.Sum256(data)
+	desc := registry.Descriptor{Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	if err := s.WriteBlob(desc, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+// storeImage stores an image with the given layers, extracted as a single
+// file each, tags it as ref and returns the digest of its manifest
+func storeImage(t *testing.T, s *Store, ref string, layers ...string) string {
+	t.Helper()
+	manifest := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIManifest,
+		Config:        writeBlob(t, s, []byte(`{"config":{"Cmd":["`+ref+`"]}}`)),
+	}
+	for _, layer := range layers {
+		desc := writeBlob(t, s, []byte(layer))
+		desc.MediaType = registry.MediaTypeOCILayer
+		if err := os.MkdirAll(s.LayerDir(desc.Digest), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(s.LayerDir(desc.Digest)+"/file", []byte(layer), 0644); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := writeBlob(t, s, data).Digest
+	if err := s.Tag(ref, digest); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+// stored reports whether the blob with content is still in the store
+func stored(s *Store, content string) bool {
+	sum := sha256.Sum256([]byte(content))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	_, err := os.Stat(s.BlobPath(digest))
+	return err == nil
+}
+
+func TestRemove(t *testing.T) {
+	s := NewStore(t.TempDir())
+	storeImage(t, s, "app:1", "base", "app")
+	storeImage(t, s, "other:1", "base", "other")
+
+	deleted, err := s.Remove("app:1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The manifest, the config and the layer nothing else uses
+	if len(deleted) != 3 {
+		t.Errorf("Remove deleted %v, want 3 blobs", deleted)
+	}
+	if stored(s, "app") {
+		t.Error("layer only app:1 used is still stored")
+	}
+	if !stored(s, "base") {
+		t.Error("layer other:1 uses was deleted")
+	}
+	if _, err := s.Resolve("app:1"); err == nil {
+		t.Error("app:1 still resolves")
+	}
+}
+
+func TestRemoveInUse(t *testing.T) {
+	s := NewStore(t.TempDir())
+	digest := storeImage(t, s, "app:1", "base", "app")
+	img, err := s.Resolve("app:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layers, err := s.Layers(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeImage(t, s, "other:1", "base", "other")
+
+	// A container created from app:1 keeps everything once it is untagged,
+	// along with the layers it shares with images removed later. Manifests
+	// of containers that are gone from the store are ignored
+	missing := "sha256:" + strings.Repeat("0", 64)
+	inUse := []string{digest, missing, "bogus"}
+	for _, ref := range []string{"app:1", "other:1"} {
+		deleted, err := s.Remove(ref, inUse)
+		if err != nil {
+			t.Fatalf("Remove(%s) failed: %v", ref, err)
+		}
+		if slices.Contains(deleted, digest) {
+			t.Errorf("Remove(%s) deleted the manifest of a container", ref)
+		}
+	}
+	for _, content := range []string{"base", "app"} {
+		if !stored(s, content) {
+			t.Errorf("layer %s of a container was deleted", content)
+		}
+	}
+	for _, layer := range layers {
+		if _, err := os.Stat(layer + "/file"); err != nil {
+			t.Errorf("extracted layer of a container was deleted: %v", err)
+		}
+	}
+	if stored(s, "other") {
+		t.Error("layer only other:1 used is still stored")
+	}
+}
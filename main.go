@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
 	"github.com/beltranaceves/gontainers/cli"
+	"github.com/beltranaceves/gontainers/container"
+	_ "github.com/beltranaceves/gontainers/container/nsenter"
 )
 
 func main() {
@@ -16,6 +19,11 @@ func main() {
 
 	cmd := cli.NewCLI()
 	if err := cmd.Execute(); err != nil {
+		// Pass the container's exit status on instead of a generic failure
+		var exitErr *container.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		log.Fatal(err)
 	}
 }
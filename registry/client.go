@@ -0,0 +1,452 @@
+// Package registry is a client for the OCI distribution API that container
+// registries such as Docker Hub implement. It resolves image references to
+// manifests, fetches blobs and lists tags, authenticating as the registry
+// asks for it through WWW-Authenticate challenges.
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Client talks to one or more registries. It is safe for concurrent use
+type Client struct {
+	HTTPClient *http.Client
+
+	// Credentials returns the username and password to answer challenges of
+	// a registry host with, empty ones to pull anonymously
+	Credentials func(registry string) (username, password string, err error)
+
+	mu sync.Mutex
+	// authorizations caches the Authorization header per registry and repository
+	authorizations map[string]string
+}
+
+func NewClient() *Client {
+	return &Client{
+		HTTPClient:     http.DefaultClient,
+		Credentials:    LoadCredentials,
+		authorizations: map[string]string{},
+	}
+}
+
+// ResolveManifest gets the manifest ref points to. Multi-platform images
+// are resolved through their index to the manifest for platform
+func (c *Client) ResolveManifest(ref ImageReference, platform Platform) (*Manifest, error) {
+	data, mediaType, digest, err := c.fetchManifest(ref, ref.Reference())
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerList {
+		var index Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse index: %v", err)
+		}
+
+		var selected *Descriptor
+		var available []string
+		for i, entry := range index.Manifests {
+			if entry.Platform == nil {
+				continue
+			}
+			if platform.Matches(*entry.Platform) {
+				selected = &index.Manifests[i]
+				break
+			}
+			available = append(available, entry.Platform.String())
+		}
+		if selected == nil {
+			return nil, fmt.Errorf("no manifest for platform %s, available: %s", platform, strings.Join(available, ", "))
+		}
+
+		if data, mediaType, digest, err = c.fetchManifest(ref, selected.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	if mediaType != MediaTypeOCIManifest && mediaType != MediaTypeDockerManifest {
+		return nil, fmt.Errorf("unsupported manifest media type: %s", mediaType)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	manifest.Digest = digest
+	manifest.Raw = data
+	return &manifest, nil
+}
+
+// fetchManifest gets the manifest or index a tag or digest points to,
+// along with its media type and digest
+func (c *Client) fetchManifest(ref ImageReference, reference string) ([]byte, string, string, error) {
+	header := http.Header{}
+	header.Set("Accept", acceptedManifestMediaTypes)
+	resp, err := c.do(ref, "GET", c.url(ref, "manifests", reference), header)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", responseError("manifest request", resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// Registries do not always set the header, the document names its own type
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	var document struct {
+		MediaType string `json:"mediaType"`
+		Manifests []any  `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, "", "", fmt.Errorf("failed to parse manifest: %v", err)
+	}
+	if document.MediaType != "" {
+		mediaType = document.MediaType
+	} else if mediaType == "" || mediaType == "application/json" {
+		mediaType = MediaTypeOCIManifest
+		if document.Manifests != nil {
+			mediaType = MediaTypeOCIIndex
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(reference, "sha256:") && digest != reference {
+		return nil, "", "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", reference, digest)
+	}
+	return data, mediaType, digest, nil
+}
+
+// FetchBlob streams the blob with the given digest, such as a layer or an
+// image config, starting at offset to resume an interrupted download. It
+// returns where the stream really starts, which is 0 when the registry
+// ignores the range. The caller has to close it
+func (c *Client) FetchBlob(ref ImageReference, digest string, offset int64) (io.ReadCloser, int64, error) {
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Blobs are often redirected to a CDN, which the http client follows
+	// without passing our Authorization header on
+	resp, err := c.do(ref, "GET", c.url(ref, "blobs", digest), header)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, 0, nil
+	case http.StatusPartialContent:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("blob download returned unexpected range %q", resp.Header.Get("Content-Range"))
+		}
+		return resp.Body, start, nil
+	default:
+		defer resp.Body.Close()
+		return nil, 0, responseError("blob download", resp)
+	}
+}
+
+// ListTags returns every tag of the repository ref belongs to
+func (c *Client) ListTags(ref ImageReference) ([]string, error) {
+	var tags []string
+	next := c.url(ref, "tags", "list")
+	for next != "" {
+		resp, err := c.do(ref, "GET", next, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = nil
+		if resp.StatusCode != http.StatusOK {
+			err = responseError("tags request", resp)
+		} else if decodeErr := json.NewDecoder(resp.Body).Decode(&page); decodeErr != nil {
+			err = fmt.Errorf("failed to parse tags: %v", decodeErr)
+		}
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		// Large repositories are paginated with a Link: <...>; rel="next" header
+		current := next
+		next = ""
+		if start, end := strings.Index(link, "<"), strings.Index(link, ">"); start >= 0 && end > start && strings.Contains(link, `rel="next"`) {
+			base, err := url.Parse(current)
+			if err != nil {
+				return nil, err
+			}
+			relative, err := url.Parse(link[start+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Link header %q: %v", link, err)
+			}
+			next = base.ResolveReference(relative).String()
+		}
+	}
+	return tags, nil
+}
+
+// url builds an API URL such as https://registry/v2/library/alpine/manifests/latest
+func (c *Client) url(ref ImageReference, kind, reference string) string {
+	return fmt.Sprintf("%s%s/%s/%s", baseURL(ref.Registry), ref.Repo, kind, reference)
+}
+
+// baseURL is the root of the API of a registry host. Registries on the
+// local machine usually do not have a certificate
+func baseURL(registry string) string {
+	if registry == dockerHub {
+		registry = "registry-1.docker.io"
+	}
+	scheme := "https"
+	host, _, err := net.SplitHostPort(registry)
+	if err != nil {
+		// No port, an IPv6 address is still in brackets
+		host = strings.TrimSuffix(strings.TrimPrefix(registry, "["), "]")
+	}
+	if host == "localhost" || host == "127.0.0.1" || host == "::1" {
+		scheme = "http"
+	}
+	return scheme + "://" + registry + "/v2/"
+}
+
+// do sends a request, answering an authentication challenge once if the
+// registry asks for one. The authorization is kept for later requests to
+// the same repository
+func (c *Client) do(ref ImageReference, method, endpoint string, header http.Header) (*http.Response, error) {
+	key := ref.Registry + "/" + ref.Repo
+
+	c.mu.Lock()
+	authorization := c.authorizations[key]
+	c.mu.Unlock()
+
+	resp, err := c.send(method, endpoint, header, authorization)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if challenge == "" {
+		return nil, fmt.Errorf("%s: unauthorized without an authentication challenge", endpoint)
+	}
+
+	authorization, err = c.authorize(ref, challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.authorizations[key] = authorization
+	c.mu.Unlock()
+
+	return c.send(method, endpoint, header, authorization)
+}
+
+func (c *Client) send(method, endpoint string, header http.Header, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// authorize answers a WWW-Authenticate challenge with the value of an
+// Authorization header
+func (c *Client) authorize(ref ImageReference, challenge string) (string, error) {
+	var username, password string
+	if c.Credentials != nil {
+		var err error
+		if username, password, err = c.Credentials(ref.Registry); err != nil {
+			return "", fmt.Errorf("failed to get credentials for %s: %v", ref.Registry, err)
+		}
+	}
+
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return c.fetchToken(ref, params, username, password)
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("%s requires credentials, log in first", ref.Registry)
+		}
+		return basicAuthorization(username, password), nil
+	default:
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+}
+
+// fetchToken gets a bearer token from the realm named in the challenge,
+// identifying with the credentials if there are any
+func (c *Client) fetchToken(ref ImageReference, params map[string]string, username, password string) (string, error) {
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge from %s without a realm", ref.Registry)
+	}
+	tokenURL, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("invalid realm %q: %v", realm, err)
+	}
+
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" && ref.Repo != "" {
+		scope = "repository:" + ref.Repo + ":pull"
+	}
+	if scope != "" {
+		query.Set("scope", scope)
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", tokenURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get auth token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("authentication to %s failed, check your credentials", ref.Registry)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError("auth request", resp)
+	}
+
+	// Older token servers only send access_token, some only token
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse auth token: %v", err)
+	}
+	token := result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+	if token == "" {
+		return "", fmt.Errorf("auth server for %s returned no token", ref.Registry)
+	}
+	return "Bearer " + token, nil
+}
+
+// parseChallenge splits a challenge such as
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+// into its scheme and parameters. Quoted values may contain commas
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimLeft(value, " ")
+
+		if strings.HasPrefix(value, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				b.WriteByte(value[i])
+			}
+			params[key] = b.String()
+			rest = value[min(i+1, len(value)):]
+		} else {
+			end := strings.Index(value, ",")
+			if end < 0 {
+				end = len(value)
+			}
+			params[key] = strings.TrimSpace(value[:end])
+			rest = value[end:]
+		}
+	}
+	return scheme, params
+}
+
+// Login checks that username and password are accepted by a registry host
+func (c *Client) Login(registry, username, password string) error {
+	ref := ImageReference{Registry: registry}
+	endpoint := baseURL(registry)
+
+	resp, err := c.send("GET", endpoint, nil, "")
+	if err != nil {
+		return err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		// Registries that do not ask for authentication accept anyone
+		return nil
+	}
+
+	scheme, params := parseChallenge(challenge)
+	authorization := basicAuthorization(username, password)
+	if strings.EqualFold(scheme, "bearer") {
+		if authorization, err = c.fetchToken(ref, params, username, password); err != nil {
+			return err
+		}
+	}
+
+	resp, err = c.send("GET", endpoint, nil, authorization)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("authentication to %s failed, check your credentials", registry)
+	}
+	return nil
+}
+
+// basicAuthorization is the Authorization header value for basic auth
+func basicAuthorization(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func responseError(what string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s failed with status: %s, body: %s", what, resp.Status, strings.TrimSpace(string(body)))
+}
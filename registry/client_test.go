@@ -0,0 +1,24 @@
+package registry
+
+import "testing"
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		registry string
+		want     string
+	}{
+		{"docker.io", "https://registry-1.docker.io/v2/"},
+		{"ghcr.io", "https://ghcr.io/v2/"},
+		{"example.com:5000", "https://example.com:5000/v2/"},
+		{"localhost", "http://localhost/v2/"},
+		{"localhost:5000", "http://localhost:5000/v2/"},
+		{"127.0.0.1:5000", "http://127.0.0.1:5000/v2/"},
+		{"[::1]", "http://[::1]/v2/"},
+		{"[::1]:5000", "http://[::1]:5000/v2/"},
+	}
+	for _, test := range tests {
+		if got := baseURL(test.registry); got != test.want {
+			t.Errorf("baseURL(%q) = %q, want %q", test.registry, got, test.want)
+		}
+	}
+}
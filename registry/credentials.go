@@ -0,0 +1,219 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubServer is the key Docker Hub credentials are stored under
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// ServerAddress returns the key credentials for a registry host are stored
+// under in config.json, which differs from the host for Docker Hub
+func ServerAddress(registry string) string {
+	switch registry {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io", dockerHubServer:
+		return dockerHubServer
+	}
+	return registry
+}
+
+// ConfigPath returns the docker config.json credentials are kept in,
+// honoring $DOCKER_CONFIG like docker does
+func ConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// dockerConfig is the part of config.json about credentials. Everything
+// else in the file is kept as is when it is written back
+type dockerConfig struct {
+	Auths       map[string]authEntry `json:"auths,omitempty"`
+	CredsStore  string               `json:"credsStore,omitempty"`
+	CredHelpers map[string]string    `json:"credHelpers,omitempty"`
+
+	raw map[string]json.RawMessage
+}
+
+type authEntry struct {
+	Auth     string `json:"auth,omitempty"` // base64 of username:password
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+func loadConfig() (*dockerConfig, error) {
+	config := &dockerConfig{raw: map[string]json.RawMessage{}}
+	data, err := os.ReadFile(ConfigPath())
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", ConfigPath(), err)
+	}
+	if err := json.Unmarshal(data, &config.raw); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", ConfigPath(), err)
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", ConfigPath(), err)
+	}
+	return config, nil
+}
+
+func (config *dockerConfig) save() error {
+	if config.Auths == nil {
+		config.Auths = map[string]authEntry{}
+	}
+	auths, err := json.Marshal(config.Auths)
+	if err != nil {
+		return err
+	}
+	config.raw["auths"] = auths
+
+	data, err := json.MarshalIndent(config.raw, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	path := ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return os.Rename(temp, path)
+}
+
+// helper returns the credential helper responsible for server, if any
+func (config *dockerConfig) helper(server string) string {
+	if helper, ok := config.CredHelpers[server]; ok {
+		return helper
+	}
+	if host := strings.TrimSuffix(strings.TrimPrefix(server, "https://"), "/v1/"); host != server {
+		if helper, ok := config.CredHelpers[host]; ok {
+			return helper
+		}
+	}
+	return config.CredsStore
+}
+
+// LoadCredentials returns the stored username and password for a registry
+// host. Both are empty when none are stored
+func LoadCredentials(registry string) (string, string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", "", err
+	}
+	server := ServerAddress(registry)
+
+	if helper := config.helper(server); helper != "" {
+		var credentials struct {
+			Username string `json:"Username"`
+			Secret   string `json:"Secret"`
+		}
+		out, err := runHelper(helper, "get", server)
+		if err != nil {
+			// Helpers report missing credentials as an error
+			if strings.Contains(err.Error(), "credentials not found") {
+				return "", "", nil
+			}
+			return "", "", err
+		}
+		if err := json.Unmarshal(out, &credentials); err != nil {
+			return "", "", fmt.Errorf("invalid output of docker-credential-%s: %v", helper, err)
+		}
+		return credentials.Username, credentials.Secret, nil
+	}
+
+	entry, ok := config.Auths[server]
+	if !ok {
+		return "", "", nil
+	}
+	if entry.Auth == "" {
+		return entry.Username, entry.Password, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid auth for %s in %s: %v", server, ConfigPath(), err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid auth for %s in %s", server, ConfigPath())
+	}
+	return username, password, nil
+}
+
+// StoreCredentials saves credentials for a registry host, in the credential
+// helper if one is configured and in config.json otherwise
+func StoreCredentials(registry, username, password string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	server := ServerAddress(registry)
+
+	if helper := config.helper(server); helper != "" {
+		input, err := json.Marshal(map[string]string{
+			"ServerURL": server,
+			"Username":  username,
+			"Secret":    password,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = runHelper(helper, "store", string(input))
+		return err
+	}
+
+	if config.Auths == nil {
+		config.Auths = map[string]authEntry{}
+	}
+	config.Auths[server] = authEntry{Auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password))}
+	return config.save()
+}
+
+// EraseCredentials removes the stored credentials of a registry host
+func EraseCredentials(registry string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	server := ServerAddress(registry)
+
+	if helper := config.helper(server); helper != "" {
+		if _, err := runHelper(helper, "erase", server); err != nil && !strings.Contains(err.Error(), "credentials not found") {
+			return err
+		}
+	}
+
+	if _, ok := config.Auths[server]; !ok {
+		return nil
+	}
+	delete(config.Auths, server)
+	return config.save()
+}
+
+// runHelper runs docker-credential-<helper> with input on stdin, following
+// the docker credential helper protocol
+func runHelper(helper, action, input string) ([]byte, error) {
+	cmd := exec.Command("docker-credential-"+helper, action)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stdout.String() + " " + stderr.String())
+		return nil, fmt.Errorf("docker-credential-%s %s: %v: %s", helper, action, err, message)
+	}
+	return stdout.Bytes(), nil
+}
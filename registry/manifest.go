@@ -0,0 +1,54 @@
+package registry
+
+// Media types of the manifests a registry may answer with. Indexes and
+// manifest lists point to one manifest per platform
+const (
+	MediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Media types of layers. Non-distributable and foreign layers are meant
+// to be fetched from elsewhere and may not be pushed to or pulled from a
+// registry
+const (
+	MediaTypeOCILayer                 = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip             = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd             = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeOCINondistributableLayer = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeDockerLayer              = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer       = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+// acceptedManifestMediaTypes is sent as the Accept header of manifest requests
+const acceptedManifestMediaTypes = MediaTypeOCIIndex + ", " + MediaTypeOCIManifest + ", " + MediaTypeDockerList + ", " + MediaTypeDockerManifest
+
+// Descriptor points to a blob or manifest by digest
+type Descriptor struct {
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Digest    string    `json:"digest"`
+	Platform  *Platform `json:"platform,omitempty"`
+}
+
+// Manifest represents an image manifest, the config and layers of an
+// image for a single platform
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+
+	// Digest is the digest of the manifest itself, computed over Raw, the
+	// manifest exactly as the registry sent it
+	Digest string `json:"-"`
+	Raw    []byte `json:"-"`
+}
+
+// Index represents an OCI image index or Docker manifest list
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []Descriptor `json:"manifests"`
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform identifies the operating system and CPU an image is built for
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// DefaultPlatform is the platform of the running binary
+func DefaultPlatform() Platform {
+	platform := Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	switch runtime.GOARCH {
+	case "arm64":
+		platform.Variant = "v8"
+	case "arm":
+		platform.Variant = "v7"
+	}
+	return platform
+}
+
+// ParsePlatform parses "os/arch[/variant]", such as "linux/arm64/v8"
+func ParsePlatform(value string) (Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", value)
+	}
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// Matches reports whether an image built for other runs on p. A missing
+// variant on either side matches any, and arm64 is always v8
+func (p Platform) Matches(other Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	variant, otherVariant := p.Variant, other.Variant
+	if p.Architecture == "arm64" {
+		variant, otherVariant = strings.TrimPrefix(variant, "v8"), strings.TrimPrefix(otherVariant, "v8")
+	}
+	return variant == "" || otherVariant == "" || variant == otherVariant
+}
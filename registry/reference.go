@@ -0,0 +1,105 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dockerHub is the registry of references without one
+const dockerHub = "docker.io"
+
+// maxNameLength is the longest a registry and repository may be together
+const maxNameLength = 255
+
+// The grammar of references, as defined by the distribution project:
+//
+//	reference := name [ ":" tag ] [ "@" digest ]
+//	name      := [ domain "/" ] path-component [ "/" path-component ]*
+//	domain    := host [ ":" port ]
+const (
+	pathComponentExpr   = `[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*`
+	domainComponentExpr = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	domainExpr          = `(?:` + domainComponentExpr + `(?:\.` + domainComponentExpr + `)*|\[[a-fA-F0-9:]+\])(?::[0-9]+)?`
+	tagExpr             = `[\w][\w.-]{0,127}`
+	digestExpr          = `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}`
+)
+
+var referencePattern = regexp.MustCompile(`^(?:(` + domainExpr + `)/)?(` + pathComponentExpr + `(?:/` + pathComponentExpr + `)*)(?::(` + tagExpr + `))?(?:@(` + digestExpr + `))?$`)
+
+// ImageReference represents an image reference such as "alpine",
+// "localhost:5000/team/app:1.2" or "alpine@sha256:..."
+type ImageReference struct {
+	Registry string
+	Repo     string
+	Tag      string // empty when only Digest is given
+	Digest   string
+}
+
+// ParseImageReference parses an image reference string into its components.
+// References without a registry are on Docker Hub, and Docker Hub
+// repositories without a namespace are in library, so alpine becomes
+// docker.io/library/alpine:latest
+func ParseImageReference(ref string) (ImageReference, error) {
+	match := referencePattern.FindStringSubmatch(ref)
+	if match == nil {
+		if referencePattern.MatchString(strings.ToLower(ref)) {
+			return ImageReference{}, fmt.Errorf("invalid reference %q, repository name must be lowercase", ref)
+		}
+		return ImageReference{}, fmt.Errorf("invalid reference %q", ref)
+	}
+	registry, repo, tag, digest := match[1], match[2], match[3], match[4]
+
+	// The first component is only a registry when it can't be a path
+	// component, like docker.io, localhost or example.com:5000
+	if registry != "" && !strings.ContainsAny(registry, ".:[") && registry != "localhost" {
+		repo = registry + "/" + repo
+		registry = ""
+	}
+	if repo != strings.ToLower(repo) {
+		return ImageReference{}, fmt.Errorf("invalid reference %q, repository name must be lowercase", ref)
+	}
+	if len(registry)+1+len(repo) > maxNameLength {
+		return ImageReference{}, fmt.Errorf("invalid reference %q, name longer than %d characters", ref, maxNameLength)
+	}
+
+	switch registry {
+	case "", "index.docker.io", "registry-1.docker.io":
+		registry = dockerHub
+	}
+	if registry == dockerHub && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	if tag == "" && digest == "" {
+		tag = "latest"
+	}
+
+	return ImageReference{
+		Registry: registry,
+		Repo:     repo,
+		Tag:      tag,
+		Digest:   digest,
+	}, nil
+}
+
+// String returns the canonical form of the reference, with the registry
+// and tag always spelled out
+func (r ImageReference) String() string {
+	s := r.Registry + "/" + r.Repo
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Reference is what the manifest is requested by, the digest when there
+// is one since it pins the tag
+func (r ImageReference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+
+	tests := []struct {
+		ref       string
+		want      ImageReference
+		canonical string
+	}{
+		{
+			ref:       "alpine",
+			want:      ImageReference{Registry: "docker.io", Repo: "library/alpine", Tag: "latest"},
+			canonical: "docker.io/library/alpine:latest",
+		},
+		{
+			ref:       "alpine:3.18",
+			want:      ImageReference{Registry: "docker.io", Repo: "library/alpine", Tag: "3.18"},
+			canonical: "docker.io/library/alpine:3.18",
+		},
+		{
+			ref:       "team/app",
+			want:      ImageReference{Registry: "docker.io", Repo: "team/app", Tag: "latest"},
+			canonical: "docker.io/team/app:latest",
+		},
+		{
+			ref:       "docker.io/alpine",
+			want:      ImageReference{Registry: "docker.io", Repo: "library/alpine", Tag: "latest"},
+			canonical: "docker.io/library/alpine:latest",
+		},
+		{
+			ref:       "index.docker.io/library/alpine",
+			want:      ImageReference{Registry: "docker.io", Repo: "library/alpine", Tag: "latest"},
+			canonical: "docker.io/library/alpine:latest",
+		},
+		{
+			ref:       "localhost/app",
+			want:      ImageReference{Registry: "localhost", Repo: "app", Tag: "latest"},
+			canonical: "localhost/app:latest",
+		},
+		{
+			ref:       "localhost:5000/team/app:1.2",
+			want:      ImageReference{Registry: "localhost:5000", Repo: "team/app", Tag: "1.2"},
+			canonical: "localhost:5000/team/app:1.2",
+		},
+		{
+			ref:       "example.com:5000/app",
+			want:      ImageReference{Registry: "example.com:5000", Repo: "app", Tag: "latest"},
+			canonical: "example.com:5000/app:latest",
+		},
+		{
+			ref:       "[::1]:5000/app",
+			want:      ImageReference{Registry: "[::1]:5000", Repo: "app", Tag: "latest"},
+			canonical: "[::1]:5000/app:latest",
+		},
+		{
+			ref:       "name@" + digest,
+			want:      ImageReference{Registry: "docker.io", Repo: "library/name", Digest: digest},
+			canonical: "docker.io/library/name@" + digest,
+		},
+		{
+			ref:       "example.com/team/app:1.2@" + digest,
+			want:      ImageReference{Registry: "example.com", Repo: "team/app", Tag: "1.2", Digest: digest},
+			canonical: "example.com/team/app:1.2@" + digest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			got, err := ParseImageReference(test.ref)
+			if err != nil {
+				t.Fatalf("ParseImageReference(%q) failed: %v", test.ref, err)
+			}
+			if got != test.want {
+				t.Errorf("ParseImageReference(%q) = %+v, want %+v", test.ref, got, test.want)
+			}
+			if got.String() != test.canonical {
+				t.Errorf("String() = %q, want %q", got.String(), test.canonical)
+			}
+
+			// The canonical form is what the image store keys on, so it has
+			// to parse back to the same reference
+			again, err := ParseImageReference(got.String())
+			if err != nil {
+				t.Fatalf("ParseImageReference(%q) failed: %v", got.String(), err)
+			}
+			if again != got {
+				t.Errorf("ParseImageReference(%q) = %+v, want %+v", got.String(), again, got)
+			}
+		})
+	}
+}
+
+func TestParseImageReferenceErrors(t *testing.T) {
+	refs := []string{
+		"Foo/bar",
+		"alpine:" + strings.Repeat("a", 129),
+		"alpine:",
+		"alpine:-tag",
+		"alpine:3.18:edge",
+		"alpine@sha256:abc123",
+		"alpine@" + strings.Repeat("a", 64),
+		"",
+		"a//b",
+		"example.com/" + strings.Repeat("a", 250),
+	}
+	for _, ref := range refs {
+		if got, err := ParseImageReference(ref); err == nil {
+			t.Errorf("ParseImageReference(%q) = %+v, want an error", ref, got)
+		}
+	}
+}
+
+func TestReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0123456789abcdef", 4)
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"alpine", "latest"},
+		{"alpine:3.18", "3.18"},
+		{"alpine@" + digest, digest},
+		{"alpine:3.18@" + digest, digest},
+	}
+	for _, test := range tests {
+		parsed, err := ParseImageReference(test.ref)
+		if err != nil {
+			t.Fatalf("ParseImageReference(%q) failed: %v", test.ref, err)
+		}
+		if got := parsed.Reference(); got != test.want {
+			t.Errorf("Reference() of %q = %q, want %q", test.ref, got, test.want)
+		}
+	}
+}
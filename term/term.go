@@ -0,0 +1,105 @@
+// Package term wraps the terminal ioctls the container runtime and the
+// command line both need: telling terminals apart, raw mode, echo and
+// window sizes
+package term
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Winsize is the size of a terminal, as TIOCGWINSZ reports it
+type Winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+// IsTerminal reports whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	_, err := GetTermios(f)
+	return err == nil
+}
+
+func GetTermios(f *os.File) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	if err := Ioctl(f, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		return nil, err
+	}
+	return &termios, nil
+}
+
+func SetTermios(f *os.File, termios *syscall.Termios) error {
+	return Ioctl(f, syscall.TCSETS, unsafe.Pointer(termios))
+}
+
+// MakeRaw disables line editing, echo and signal keys like cfmakeraw(3)
+// does and returns the previous settings
+func MakeRaw(f *os.File) (*syscall.Termios, error) {
+	old, err := GetTermios(f)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	if err := SetTermios(f, &raw); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// DisableEcho stops f from echoing what is typed, for reading passwords,
+// and returns the previous settings
+func DisableEcho(f *os.File) (*syscall.Termios, error) {
+	old, err := GetTermios(f)
+	if err != nil {
+		return nil, err
+	}
+	silent := *old
+	silent.Lflag &^= syscall.ECHO
+	if err := SetTermios(f, &silent); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+func GetWinsize(f *os.File) (Winsize, error) {
+	var size Winsize
+	err := Ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&size))
+	return size, err
+}
+
+func SetWinsize(f *os.File, size Winsize) error {
+	return Ioctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+// Ioctl goes through SyscallConn because File.Fd would switch the file to
+// blocking mode, after which Close no longer interrupts a pending Read
+func Ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}